@@ -1,19 +1,215 @@
-// Package broker contains message broker abstraction and NATS JetStream broker implementation.
+// Package broker contains message broker abstraction and NATS JetStream and Google Cloud Pub/Sub broker
+// implementations. Kafka broker is implemented in package kafka.
 package broker
 
-// Message contains data from the broker.
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Message contains data from the broker. Ack and Nack of messages created by brokers in this package are
+// idempotent, only the first call of either one takes effect.
 type Message struct {
-	Data       []byte
-	Ack        func()
+	Data []byte
+	Ack  func()
+	// Nack tells the broker message was not processed and should be redelivered as soon as possible.
+	Nack       func()
 	InProgress func()
+	// Extend optionally sets ack deadline to the given duration from now. It's nil if the broker can only restart
+	// its ack wait by InProgress.
+	Extend  func(d time.Duration)
+	headers map[string]string
+	key     []byte
+	id      string
+	err     error
+}
+
+// NewErrMessage creates message carrying an error reported by the broker instead of data.
+// Such message has nothing to acknowledge, so its Ack, Nack and InProgress are no-op.
+func NewErrMessage(err error) Message {
+	return Message{
+		Data:       nil,
+		Ack:        func() {},
+		Nack:       func() {},
+		InProgress: func() {},
+		Extend:     nil,
+		headers:    nil,
+		key:        nil,
+		id:         "",
+		err:        err,
+	}
+}
+
+// WithKey returns copy of the message with the key. It's meant for brokers implemented outside this package, like
+// fakes in tests.
+func (m Message) WithKey(key []byte) Message {
+	m.key = key
+
+	return m
+}
+
+// WithHeaders returns copy of the message with the headers. It's meant for brokers implemented outside this
+// package, like fakes in tests.
+func (m Message) WithHeaders(headers map[string]string) Message {
+	m.headers = headers
+
+	return m
+}
+
+// WithID returns copy of the message with the ID. It's meant for brokers implemented outside this package, like
+// fakes in tests.
+func (m Message) WithID(id string) Message {
+	m.id = id
+
+	return m
+}
+
+// Headers returns message headers. If header has multiple values, only the first one is returned.
+func (m Message) Headers() map[string]string {
+	return m.headers
+}
+
+// Key returns message key used by brokers which partition messages, or nil if message has no key.
+func (m Message) Key() []byte {
+	return m.key
+}
+
+// ID returns identifier assigned to the message by the broker, stable across redeliveries, or an empty string if
+// the broker doesn't provide one.
+func (m Message) ID() string {
+	return m.id
+}
+
+// Err returns an error delivered by the broker instead of data or nil for regular messages.
+func (m Message) Err() error {
+	return m.err
 }
 
 // Broker defines common broker methods.
 type Broker interface {
-	// Sub subscribes to broker and returns a channel to receive messages.
+	// Sub subscribes to broker and returns a channel to receive messages. Brokers not able to start at the
+	// configured start position return ErrUnsupported.
 	Sub() (<-chan Message, error)
-	// Pub synchronously publishes a message to broker.
-	Pub(message []byte) error
+	// Pub synchronously publishes a message to broker and returns once the broker confirmed it was persisted.
+	// Implementations must not retain message after return because the caller may reuse its underlying array.
+	Pub(message []byte, opts ...PubOption) error
 	// Exit gracefully shuts down subscriber.
 	Exit()
 }
+
+// Pauser is optionally implemented by brokers which can temporarily stop delivering messages, like brokers
+// prefetching messages in the background.
+type Pauser interface {
+	// Pause stops delivering new messages until Resume is called.
+	Pause()
+	// Resume continues delivering messages.
+	Resume()
+}
+
+// Prefetcher is optionally implemented by brokers which limit number of messages delivered but not yet acknowledged.
+type Prefetcher interface {
+	// Prefetch returns maximum number of unacknowledged messages delivered at once, zero if not limited or unknown.
+	Prefetch() int
+}
+
+// DeadLetterReasonHeader carries reason why the message was dead-lettered.
+const DeadLetterReasonHeader = "dead-letter-reason"
+
+// DeadLetterer is optionally implemented by brokers which can move messages failing permanently to a dead-letter
+// destination. Brokers without configured destination return ErrUnsupported.
+type DeadLetterer interface {
+	// DeadLetter publishes message data and headers to the dead-letter destination together with the reason.
+	// The message itself is not acknowledged.
+	DeadLetter(msg Message, reason error) error
+}
+
+// PublishDeadLetter publishes message to the dead-letter subject using Pub of the broker, with its headers and the
+// reason in DeadLetterReasonHeader. It returns ErrUnsupported if subject is empty. It's meant to be used by
+// DeadLetterer implementations.
+func PublishDeadLetter(b Broker, subject string, msg Message, reason error) error {
+	if subject == "" {
+		return ErrUnsupported
+	}
+
+	opts := make([]PubOption, 0, len(msg.headers)+2) //nolint:gomnd
+
+	for key, value := range msg.headers {
+		opts = append(opts, WithHeader(key, value))
+	}
+
+	opts = append(opts, WithSubject(subject), WithHeader(DeadLetterReasonHeader, reason.Error()))
+
+	if err := b.Pub(msg.Data, opts...); err != nil {
+		return fmt.Errorf("dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// acknowledgeOnce wraps ack and nack functions so that only the first call of either one takes effect.
+func acknowledgeOnce(ack, nack func()) (func(), func()) {
+	var once sync.Once
+
+	return func() { once.Do(ack) }, func() { once.Do(nack) }
+}
+
+// PubOptions contains optional publishing parameters.
+type PubOptions struct {
+	// If provided, overrides default produce subject.
+	Subject string
+	// Headers attached to the published message.
+	Headers map[string]string
+	// If provided, message is published with this key. Brokers without keyed messages ignore it.
+	Key []byte
+	// If provided, broker drops the message if another one with the same key was published recently. Brokers
+	// without publish deduplication ignore it.
+	IdempotencyKey string
+}
+
+// PubOption sets optional publishing parameter.
+type PubOption func(o *PubOptions)
+
+// NewPubOptions applies publishing options. It's meant to be used by Broker implementations.
+func NewPubOptions(opts ...PubOption) *PubOptions {
+	options := &PubOptions{} //nolint:exhaustruct
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// WithSubject publishes message to the given subject instead of the default one.
+func WithSubject(subject string) PubOption {
+	return func(o *PubOptions) {
+		o.Subject = subject
+	}
+}
+
+// WithHeader attaches header to the published message.
+func WithHeader(key, value string) PubOption {
+	return func(o *PubOptions) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+
+		o.Headers[key] = value
+	}
+}
+
+// WithKey publishes message with the given key.
+func WithKey(key []byte) PubOption {
+	return func(o *PubOptions) {
+		o.Key = key
+	}
+}
+
+// WithIdempotencyKey publishes message with the given idempotency key, so duplicate publishes caused by retries are
+// deduplicated by the broker.
+func WithIdempotencyKey(key string) PubOption {
+	return func(o *PubOptions) {
+		o.IdempotencyKey = key
+	}
+}
@@ -0,0 +1,35 @@
+package broker
+
+import "testing"
+
+func TestAcknowledgeOnce(t *testing.T) {
+	t.Parallel()
+
+	var acked, nacked int
+
+	ack, nack := acknowledgeOnce(func() { acked++ }, func() { nacked++ })
+
+	ack()
+	ack()
+	nack()
+
+	if acked != 1 || nacked != 0 {
+		t.Errorf("want single ack, got %d acks and %d nacks", acked, nacked)
+	}
+}
+
+func TestNegativeAcknowledgeOnce(t *testing.T) {
+	t.Parallel()
+
+	var acked, nacked int
+
+	ack, nack := acknowledgeOnce(func() { acked++ }, func() { nacked++ })
+
+	nack()
+	ack()
+	nack()
+
+	if acked != 0 || nacked != 1 {
+		t.Errorf("want single nack, got %d acks and %d nacks", acked, nacked)
+	}
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultConnectTimeout = 5 * time.Second
+
+// Errors.
+var (
+	ErrMissingURL      = errors.New("missing url")
+	ErrMissingUsername = errors.New("password provided without username")
+	ErrMissingPassword = errors.New("username provided without password")
+	ErrAmbiguousAuth   = errors.New("token and username/password are mutually exclusive")
+	ErrInvalidTimeout  = errors.New("invalid timeout")
+)
+
+// ConnConfig contains connection parameters shared by all broker implementations.
+type ConnConfig struct {
+	// Broker URL. Multiple comma separated URLs may be provided if broker supports clustering.
+	URL string
+	// Optional. If provided, connection will be secured using TLS.
+	TLS *tls.Config
+	// Optional. Requires Password. Mutually exclusive with Token.
+	Username string
+	// Optional. Requires Username. Mutually exclusive with Token.
+	Password string
+	// Optional. Mutually exclusive with Username and Password.
+	Token string
+	// How long to wait for connection to be established. Default 5s.
+	ConnectTimeout time.Duration
+}
+
+// Validate checks if configuration is complete and consistent and sets defaults.
+func (c *ConnConfig) Validate() error {
+	if c.URL == "" {
+		return ErrMissingURL
+	}
+
+	if c.Username != "" && c.Password == "" {
+		return ErrMissingPassword
+	}
+
+	if c.Username == "" && c.Password != "" {
+		return ErrMissingUsername
+	}
+
+	if c.Token != "" && c.Username != "" {
+		return ErrAmbiguousAuth
+	}
+
+	if c.ConnectTimeout < 0 {
+		return fmt.Errorf("connect: %w", ErrInvalidTimeout)
+	}
+
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = defaultConnectTimeout
+	}
+
+	return nil
+}
@@ -0,0 +1,538 @@
+// Package kafka contains Apache Kafka broker implementation, separate from package broker so only its users link
+// the Kafka client.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"go.ectobit.com/oxeye/broker"
+)
+
+const (
+	defaultCommitInterval  = time.Second
+	defaultAckWait         = 30 * time.Second
+	defaultRedeliveryDelay = time.Second
+	defaultBatchTimeout    = 10 * time.Millisecond
+	fetchBackoff           = time.Second
+)
+
+var (
+	_ broker.Broker       = (*Broker)(nil)
+	_ broker.DeadLetterer = (*Broker)(nil)
+	_ broker.Pauser       = (*Broker)(nil)
+)
+
+// Broker implements broker.Broker interface for Apache Kafka.
+// Exported field Debug can be used for debugging.
+type Broker struct {
+	reader  *kafkago.Reader
+	writer  *kafkago.Writer
+	config  *Config
+	offsets *offsetTracker
+	paused  pauseGate
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+	Debug   func(s string)
+}
+
+// Config contains Kafka configuration parameters.
+type Config struct {
+	// Addresses of Kafka brokers
+	Brokers []string
+	// Consume this topic
+	ConsumeTopic string
+	// Optional. If provided, consumer group is used and acknowledged messages are committed. Otherwise, partition
+	// zero is consumed without committing.
+	ConsumerGroup string
+	// Produce into this topic
+	ProduceTopic string
+	// How often acknowledged offsets are committed. Default 1s.
+	CommitInterval time.Duration
+	// Where to start consuming. Consumer group starts there only if it has no committed offset yet and supports
+	// only broker.StartLatest and broker.StartEarliest. Default is broker.StartLatest.
+	StartPosition broker.StartPosition
+	// Optional. If provided, messages failing permanently are published to this topic.
+	DeadLetterTopic string
+	// How long delivered message may stay unacknowledged before it's delivered again. Kafka itself doesn't
+	// redeliver, so without it unacknowledged message would block committing its partition. Default 30s.
+	AckWait time.Duration
+	// Delay before negatively acknowledged or expired message is delivered again. Default 1s.
+	RedeliveryDelay time.Duration
+	// Optional. If provided, it chooses partition of published message, which has to be below numPartitions.
+	// Default is hash of the message key.
+	Partitioner func(key, value []byte, numPartitions int) int
+}
+
+// New creates new Kafka broker implementing broker.Broker interface.
+// Message keys are used to choose partitions, so messages with the same key keep their order. Offsets are committed
+// only up to the oldest message not acknowledged yet, negatively acknowledged messages and those not acknowledged
+// within ack wait are delivered again after redelivery delay.
+func New(config *Config) *Broker {
+	return newBroker(config, kafkago.DefaultDialer, nil)
+}
+
+// Connect creates new Kafka broker using shared connection configuration. URL contains comma separated
+// addresses of Kafka brokers, which are used instead of config.Brokers. Username and password authenticate using
+// SASL PLAIN, token authentication is not supported.
+func Connect(conn *broker.ConnConfig, config *Config) (*Broker, error) {
+	if err := conn.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	if conn.Token != "" {
+		return nil, fmt.Errorf("token: %w", broker.ErrUnsupported)
+	}
+
+	config.Brokers = strings.Split(conn.URL, ",")
+
+	dialer := &kafkago.Dialer{ //nolint:exhaustruct
+		Timeout:   conn.ConnectTimeout,
+		DualStack: true,
+		TLS:       conn.TLS,
+	}
+
+	transport := &kafkago.Transport{ //nolint:exhaustruct
+		DialTimeout: conn.ConnectTimeout,
+		TLS:         conn.TLS,
+	}
+
+	if conn.Username != "" {
+		mechanism := plain.Mechanism{Username: conn.Username, Password: conn.Password}
+		dialer.SASLMechanism = mechanism
+		transport.SASL = mechanism
+	}
+
+	return newBroker(config, dialer, transport), nil
+}
+
+func newBroker(config *Config, dialer *kafkago.Dialer, transport kafkago.RoundTripper) *Broker {
+	if config.CommitInterval == 0 {
+		config.CommitInterval = defaultCommitInterval
+	}
+
+	if config.AckWait == 0 {
+		config.AckWait = defaultAckWait
+	}
+
+	if config.RedeliveryDelay == 0 {
+		config.RedeliveryDelay = defaultRedeliveryDelay
+	}
+
+	var balancer kafkago.Balancer = &kafkago.Hash{} //nolint:exhaustruct
+
+	if config.Partitioner != nil {
+		balancer = kafkago.BalancerFunc(func(msg kafkago.Message, partitions ...int) int {
+			return config.Partitioner(msg.Key, msg.Value, len(partitions))
+		})
+	}
+
+	return &Broker{ //nolint:exhaustruct
+		reader: kafkago.NewReader(kafkago.ReaderConfig{ //nolint:exhaustruct
+			Brokers:        config.Brokers,
+			GroupID:        config.ConsumerGroup,
+			Topic:          config.ConsumeTopic,
+			Dialer:         dialer,
+			CommitInterval: config.CommitInterval,
+			StartOffset:    startOffset(config.StartPosition),
+		}),
+		writer: &kafkago.Writer{ //nolint:exhaustruct
+			Addr:         kafkago.TCP(config.Brokers...),
+			Balancer:     balancer,
+			BatchTimeout: defaultBatchTimeout,
+			RequiredAcks: kafkago.RequireAll,
+			Transport:    transport,
+		},
+		config:  config,
+		offsets: newOffsetTracker(),
+		cancel:  func() {},
+		Debug:   func(string) {},
+	}
+}
+
+// Sub implements broker.Broker interface.
+func (b *Broker) Sub() (<-chan broker.Message, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := b.seek(ctx); err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	b.cancel = cancel
+	deliver := newRedeliverer(ctx)
+
+	b.wg.Add(1)
+
+	go func() {
+		defer b.wg.Done()
+
+		for {
+			b.paused.wait(ctx)
+
+			msg, err := b.reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, io.EOF) {
+					break
+				}
+
+				deliver.send(broker.NewErrMessage(fmt.Errorf("fetch: %w", err)))
+
+				select {
+				case <-time.After(fetchBackoff):
+				case <-ctx.Done():
+				}
+
+				continue
+			}
+
+			if b.config.ConsumerGroup != "" {
+				b.offsets.track(msg)
+			}
+
+			deliver.send(b.message(msg, deliver))
+		}
+
+		b.Debug("stopping consumer")
+
+		deliver.close()
+	}()
+
+	return deliver.messages, nil
+}
+
+// seek moves partition consumer to the configured start position. Consumer group starts at StartOffset.
+func (b *Broker) seek(ctx context.Context) error {
+	position := b.config.StartPosition
+	sequence, atSequence := position.Sequence()
+	at, atTime := position.Time()
+
+	if !atSequence && !atTime {
+		return nil
+	}
+
+	if b.config.ConsumerGroup != "" {
+		return fmt.Errorf("start position with consumer group: %w", broker.ErrUnsupported)
+	}
+
+	if atSequence {
+		if err := b.reader.SetOffset(int64(sequence)); err != nil {
+			return fmt.Errorf("seek: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := b.reader.SetOffsetAt(ctx, at); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+
+	return nil
+}
+
+// message converts Kafka message to broker message. Ack commits offsets acknowledged so far without gaps, Nack
+// delivers the message again, as well as expired ack wait. InProgress restarts ack wait and Extend sets it.
+func (b *Broker) message(msg kafkago.Message, deliver *redeliverer) broker.Message {
+	ack, nack := acknowledgeOnce(func() {
+		if b.config.ConsumerGroup == "" {
+			return
+		}
+
+		commit, ok := b.offsets.ack(msg)
+		if !ok {
+			return
+		}
+
+		if err := b.reader.CommitMessages(context.Background(), commit); err != nil {
+			b.Debug(fmt.Sprintf("commit: %s", err))
+		}
+	}, func() {
+		deliver.redeliver(b.config.RedeliveryDelay, func() broker.Message { return b.message(msg, deliver) })
+	})
+
+	expiry := time.AfterFunc(b.config.AckWait, nack)
+
+	return broker.Message{ //nolint:exhaustruct
+		Data: msg.Value,
+		Ack: func() {
+			expiry.Stop()
+			ack()
+		},
+		Nack: func() {
+			expiry.Stop()
+			nack()
+		},
+		// Restarting expiry of settled message is harmless, it's settled only once.
+		InProgress: func() { expiry.Reset(b.config.AckWait) },
+		Extend:     func(d time.Duration) { expiry.Reset(d) },
+	}.WithHeaders(headers(msg.Headers)).WithKey(messageKey(msg.Key)).
+		WithID(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset))
+}
+
+// Pub implements broker.Broker interface.
+func (b *Broker) Pub(data []byte, opts ...broker.PubOption) error {
+	options := broker.NewPubOptions(opts...)
+
+	msg := kafkago.Message{ //nolint:exhaustruct
+		Topic: b.config.ProduceTopic,
+		Key:   options.Key,
+		Value: data,
+	}
+
+	if options.Subject != "" {
+		msg.Topic = options.Subject
+	}
+
+	for key, value := range options.Headers {
+		msg.Headers = append(msg.Headers, kafkago.Header{Key: key, Value: []byte(value)})
+	}
+
+	if err := b.writer.WriteMessages(context.Background(), msg); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	b.Debug(fmt.Sprintf("publish topic: %s", msg.Topic))
+
+	return nil
+}
+
+// DeadLetter implements broker.DeadLetterer interface.
+func (b *Broker) DeadLetter(msg broker.Message, reason error) error {
+	return broker.PublishDeadLetter(b, b.config.DeadLetterTopic, msg, reason) //nolint:wrapcheck
+}
+
+// Pause implements broker.Pauser interface. It stops fetching messages, reader keeps at most its queue capacity
+// of messages prefetched meanwhile.
+func (b *Broker) Pause() {
+	b.paused.pause()
+}
+
+// Resume implements broker.Pauser interface.
+func (b *Broker) Resume() {
+	b.paused.resume()
+}
+
+// Exit implements broker.Broker interface.
+func (b *Broker) Exit() {
+	b.cancel()
+	b.wg.Wait()
+
+	// Closing the reader commits offsets acknowledged since the last commit.
+	if err := b.reader.Close(); err != nil {
+		b.Debug(fmt.Sprintf("close reader: %s", err))
+	}
+
+	if err := b.writer.Close(); err != nil {
+		b.Debug(fmt.Sprintf("close writer: %s", err))
+	}
+}
+
+// startOffset converts start position to Kafka start offset.
+func startOffset(position broker.StartPosition) int64 {
+	if position.IsEarliest() {
+		return kafkago.FirstOffset
+	}
+
+	return kafkago.LastOffset
+}
+
+// headers converts Kafka headers to message headers, keeping the first value of repeated ones.
+func headers(header []kafkago.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(header))
+
+	for _, h := range header {
+		if _, ok := headers[h.Key]; !ok {
+			headers[h.Key] = string(h.Value)
+		}
+	}
+
+	return headers
+}
+
+// messageKey converts Kafka message key to message key, nil if empty.
+func messageKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+
+	return key
+}
+
+// acknowledgeOnce wraps ack and nack functions so that only the first call of either one takes effect.
+func acknowledgeOnce(ack, nack func()) (func(), func()) {
+	var once sync.Once
+
+	return func() { once.Do(ack) }, func() { once.Do(nack) }
+}
+
+// redeliverer delivers messages to the subscription channel, including negatively acknowledged ones sent again,
+// and closes the channel once nothing can be delivered anymore.
+type redeliverer struct {
+	ctx      context.Context //nolint:containedctx
+	messages chan broker.Message
+	mu       sync.Mutex
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func newRedeliverer(ctx context.Context) *redeliverer {
+	return &redeliverer{ //nolint:exhaustruct
+		ctx:      ctx,
+		messages: make(chan broker.Message),
+	}
+}
+
+// send delivers message unless consumer is stopping.
+func (r *redeliverer) send(msg broker.Message) {
+	select {
+	case r.messages <- msg:
+	case <-r.ctx.Done():
+	}
+}
+
+// redeliver asynchronously delivers message created by msg again after delay. Messages not delivered before
+// consumer stopped remain uncommitted, so they are delivered after restart.
+func (r *redeliverer) redeliver(delay time.Duration, msg func() broker.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		select {
+		case <-time.After(delay):
+			r.send(msg())
+		case <-r.ctx.Done():
+		}
+	}()
+}
+
+// close closes the channel once pending redeliveries gave up.
+func (r *redeliverer) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	close(r.messages)
+}
+
+// pauseGate blocks fetching while paused. Zero value is not paused.
+type pauseGate struct {
+	mu sync.Mutex
+	// Closed once resumed, nil if not paused.
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// wait returns once the gate is not paused or ctx is done.
+func (g *pauseGate) wait(ctx context.Context) {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+	}
+}
+
+// offsetTracker tracks delivered messages per partition, so offsets are committed only once all preceding messages
+// were acknowledged, even if workers acknowledge them out of order.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[string]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []int64
+	acked   map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[string]*partitionOffsets)} //nolint:exhaustruct
+}
+
+// track records delivered message.
+func (t *offsetTracker) track(msg kafkago.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)
+
+	partition, ok := t.partitions[key]
+	if !ok {
+		partition = &partitionOffsets{pending: nil, acked: make(map[int64]bool)}
+		t.partitions[key] = partition
+	}
+
+	partition.pending = append(partition.pending, msg.Offset)
+}
+
+// ack records acknowledged message and returns the newest message which can be committed, if any.
+func (t *offsetTracker) ack(msg kafkago.Message) (kafkago.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	partition, ok := t.partitions[fmt.Sprintf("%s/%d", msg.Topic, msg.Partition)]
+	if !ok {
+		return kafkago.Message{}, false //nolint:exhaustruct
+	}
+
+	partition.acked[msg.Offset] = true
+
+	commit := int64(-1)
+
+	for len(partition.pending) > 0 && partition.acked[partition.pending[0]] {
+		commit = partition.pending[0]
+
+		delete(partition.acked, commit)
+		partition.pending = partition.pending[1:]
+	}
+
+	if commit < 0 {
+		return kafkago.Message{}, false //nolint:exhaustruct
+	}
+
+	return kafkago.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: commit}, true //nolint:exhaustruct
+}
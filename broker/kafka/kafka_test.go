@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"go.ectobit.com/oxeye/broker"
+)
+
+func TestPauseGateBlocksUntilResumed(t *testing.T) {
+	t.Parallel()
+
+	var gate pauseGate
+
+	gate.wait(context.Background())
+	gate.pause()
+	gate.pause()
+
+	passed := make(chan struct{})
+
+	go func() {
+		gate.wait(context.Background())
+		close(passed)
+	}()
+
+	select {
+	case <-passed:
+		t.Fatal("paused gate passed")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	gate.resume()
+	gate.resume()
+
+	select {
+	case <-passed:
+	case <-time.After(time.Second):
+		t.Fatal("resumed gate blocked")
+	}
+}
+
+// fakeTransport serves metadata of a single topic with given number of partitions and records partitions of
+// produced messages.
+type fakeTransport struct {
+	partitions int
+	mu         sync.Mutex
+	produced   []int32
+}
+
+func (t *fakeTransport) RoundTrip(_ context.Context, _ net.Addr, req kafkago.Request) (kafkago.Response, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		topic := metadata.ResponseTopic{Name: req.TopicNames[0]} //nolint:exhaustruct
+
+		for i := 0; i < t.partitions; i++ {
+			partition := metadata.ResponsePartition{PartitionIndex: int32(i)} //nolint:exhaustruct
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+
+		return &metadata.Response{ //nolint:exhaustruct
+			Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "localhost", Port: 9092}}, //nolint:exhaustruct
+			Topics:  []metadata.ResponseTopic{topic},
+		}, nil
+	case *produce.Request:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		response := &produce.Response{} //nolint:exhaustruct
+
+		for _, topic := range req.Topics {
+			responseTopic := produce.ResponseTopic{Topic: topic.Topic} //nolint:exhaustruct
+
+			for _, partition := range topic.Partitions {
+				t.produced = append(t.produced, partition.Partition)
+				responseTopic.Partitions = append(responseTopic.Partitions,
+					produce.ResponsePartition{Partition: partition.Partition}) //nolint:exhaustruct
+			}
+
+			response.Topics = append(response.Topics, responseTopic)
+		}
+
+		return response, nil
+	default:
+		return nil, protocol.ErrNoRecord
+	}
+}
+
+func TestPartitionerChoosesPartition(t *testing.T) {
+	t.Parallel()
+
+	transport := &fakeTransport{partitions: 4} //nolint:exhaustruct
+
+	config := &Config{ //nolint:exhaustruct
+		Brokers:      []string{"localhost:9092"},
+		ConsumeTopic: "input",
+		ProduceTopic: "orders",
+		Partitioner: func(key, _ []byte, numPartitions int) int {
+			if numPartitions != 4 {
+				t.Errorf("want 4 partitions, got %d", numPartitions)
+			}
+
+			return len(key) % numPartitions
+		},
+	}
+
+	b := newBroker(config, kafkago.DefaultDialer, transport)
+	defer b.Exit()
+
+	if err := b.Pub([]byte("data"), broker.WithKey([]byte("abc"))); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	if len(transport.produced) != 1 || transport.produced[0] != 3 {
+		t.Errorf("want message produced to partition 3, got %v", transport.produced)
+	}
+}
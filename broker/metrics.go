@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives broker measurements.
+type Metrics interface {
+	// Received counts message received from the broker.
+	Received()
+	// Published observes time spent publishing a message and its outcome.
+	Published(d time.Duration, err error)
+	// Acked observes time spent acknowledging a message.
+	Acked(d time.Duration)
+	// Nacked observes time spent negatively acknowledging a message.
+	Nacked(d time.Duration)
+}
+
+var (
+	_ Broker       = (*metricsBroker)(nil)
+	_ Pauser       = (*metricsBroker)(nil)
+	_ Prefetcher   = (*metricsBroker)(nil)
+	_ DeadLetterer = (*metricsBroker)(nil)
+)
+
+// metricsBroker decorates broker reporting measurements to metrics.
+type metricsBroker struct {
+	Broker
+	metrics Metrics
+	// Closed by Exit, so forwarding stops even if nobody receives messages anymore.
+	done   chan struct{}
+	exited sync.Once
+}
+
+// WithMetrics decorates broker to report receive rate and time spent publishing and acknowledging messages. It
+// keeps broker implementations clean while giving the same observability for all of them. Pause, Resume,
+// Prefetch and DeadLetter are passed to decorated broker if it implements Pauser, Prefetcher or DeadLetterer.
+func WithMetrics(broker Broker, metrics Metrics) Broker {
+	return &metricsBroker{Broker: broker, metrics: metrics, done: make(chan struct{})} //nolint:exhaustruct
+}
+
+// Sub implements broker.Broker interface.
+func (b *metricsBroker) Sub() (<-chan Message, error) {
+	sub, err := b.Broker.Sub()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	messages := make(chan Message)
+
+	go func() {
+		defer close(messages)
+
+		for msg := range sub {
+			if msg.Err() == nil {
+				b.metrics.Received()
+
+				msg.Ack = b.observe(msg.Ack, b.metrics.Acked)
+				msg.Nack = b.observe(msg.Nack, b.metrics.Nacked)
+			}
+
+			select {
+			case messages <- msg:
+			case <-b.done:
+				// Message nobody received is returned to the broker instead of waiting for its ack wait.
+				msg.Nack()
+
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// Pub implements broker.Broker interface.
+func (b *metricsBroker) Pub(message []byte, opts ...PubOption) error {
+	start := time.Now()
+	err := b.Broker.Pub(message, opts...)
+
+	b.metrics.Published(time.Since(start), err)
+
+	return err //nolint:wrapcheck
+}
+
+// Exit implements broker.Broker interface.
+func (b *metricsBroker) Exit() {
+	b.exited.Do(func() { close(b.done) })
+	b.Broker.Exit()
+}
+
+// Pause implements broker.Pauser interface.
+func (b *metricsBroker) Pause() {
+	if pauser, ok := b.Broker.(Pauser); ok {
+		pauser.Pause()
+	}
+}
+
+// Resume implements broker.Pauser interface.
+func (b *metricsBroker) Resume() {
+	if pauser, ok := b.Broker.(Pauser); ok {
+		pauser.Resume()
+	}
+}
+
+// Prefetch implements broker.Prefetcher interface.
+func (b *metricsBroker) Prefetch() int {
+	if prefetcher, ok := b.Broker.(Prefetcher); ok {
+		return prefetcher.Prefetch()
+	}
+
+	return 0
+}
+
+// DeadLetter implements broker.DeadLetterer interface.
+func (b *metricsBroker) DeadLetter(msg Message, reason error) error {
+	if deadLetterer, ok := b.Broker.(DeadLetterer); ok {
+		return deadLetterer.DeadLetter(msg, reason) //nolint:wrapcheck
+	}
+
+	return ErrUnsupported
+}
+
+func (b *metricsBroker) observe(acknowledge func(), observe func(d time.Duration)) func() {
+	return func() {
+		start := time.Now()
+
+		acknowledge()
+		observe(time.Since(start))
+	}
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+// chanBroker delivers messages sent to its channel.
+type chanBroker struct {
+	messages chan Message
+}
+
+func (b *chanBroker) Sub() (<-chan Message, error) { return b.messages, nil }
+
+func (b *chanBroker) Pub([]byte, ...PubOption) error { return nil }
+
+func (b *chanBroker) Exit() {}
+
+type noMetrics struct{}
+
+func (noMetrics) Received()                      {}
+func (noMetrics) Published(time.Duration, error) {}
+func (noMetrics) Acked(time.Duration)            {}
+func (noMetrics) Nacked(time.Duration)           {}
+
+func TestMetricsBrokerStopsForwardingOnExit(t *testing.T) {
+	t.Parallel()
+
+	upstream := &chanBroker{messages: make(chan Message)}
+	decorated := WithMetrics(upstream, noMetrics{})
+
+	sub, err := decorated.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nacked := make(chan struct{})
+
+	send := func(nack func()) {
+		upstream.messages <- Message{Data: []byte("data"), Ack: func() {}, Nack: nack} //nolint:exhaustruct
+	}
+
+	send(func() {})
+	<-sub
+
+	// Nobody receives the second message, so forwarding blocks holding it.
+	send(func() { close(nacked) })
+	decorated.Exit()
+
+	select {
+	case <-nacked:
+	case <-time.After(time.Second):
+		t.Fatal("held message wasn't negatively acknowledged")
+	}
+
+	select {
+	case _, ok := <-sub:
+		if ok {
+			t.Error("want subscription closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("forwarding didn't stop")
+	}
+}
@@ -13,12 +13,17 @@ const (
 	defaultReceiveChannelSize = 128
 )
 
-var _ Broker = (*NatsJetStream)(nil)
+var (
+	_ Broker       = (*NatsJetStream)(nil)
+	_ Prefetcher   = (*NatsJetStream)(nil)
+	_ DeadLetterer = (*NatsJetStream)(nil)
+)
 
 // NatsJetStream implements Broker interface for NATS JetStream broker.
 // Exported field Debug can be used for debugging.
 type NatsJetStream struct {
 	c      nats.JetStreamContext
+	nc     *nats.Conn
 	config *NatsJetStreamConfig
 	wg     sync.WaitGroup
 	done   chan struct{}
@@ -39,6 +44,12 @@ type NatsJetStreamConfig struct {
 	AckWait time.Duration
 	// MaxRedeliveries defines how many times message will be redelivered if not acknowledged. Default 2.
 	MaxRedeliveries uint8
+	// MaxAckPending caps number of delivered but not yet acknowledged messages. Default is server's default.
+	MaxAckPending int
+	// Where to start consuming if the consumer is created by subscribing. Default is StartLatest.
+	StartPosition StartPosition
+	// Optional. If provided, messages failing permanently are published to this subject.
+	DeadLetterSubject string
 }
 
 // NewNatsJetStream creates new NATS JetStream broker implementing broker.Broker interface.
@@ -63,6 +74,45 @@ func NewNatsJetStream(client nats.JetStreamContext, config *NatsJetStreamConfig)
 	}
 }
 
+// ConnectNatsJetStream connects to NATS using shared connection configuration and creates new NATS JetStream
+// broker. Created broker owns the connection and closes it on Exit.
+func ConnectNatsJetStream(conn *ConnConfig, config *NatsJetStreamConfig) (*NatsJetStream, error) {
+	if err := conn.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	opts := []nats.Option{nats.Timeout(conn.ConnectTimeout)}
+
+	if conn.TLS != nil {
+		opts = append(opts, nats.Secure(conn.TLS))
+	}
+
+	if conn.Username != "" {
+		opts = append(opts, nats.UserInfo(conn.Username, conn.Password))
+	}
+
+	if conn.Token != "" {
+		opts = append(opts, nats.Token(conn.Token))
+	}
+
+	nconn, err := nats.Connect(conn.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	jetStream, err := nconn.JetStream()
+	if err != nil {
+		nconn.Close()
+
+		return nil, fmt.Errorf("jet stream: %w", err)
+	}
+
+	b := NewNatsJetStream(jetStream, config)
+	b.nc = nconn
+
+	return b, nil
+}
+
 // Sub implements broker.Broker interface.
 func (b *NatsJetStream) Sub() (<-chan Message, error) { //nolint:funlen,cyclop
 	messages := make(chan Message)
@@ -72,13 +122,17 @@ func (b *NatsJetStream) Sub() (<-chan Message, error) { //nolint:funlen,cyclop
 
 	var err error
 
+	opts := []nats.SubOpt{nats.ManualAck(), nats.AckWait(b.config.AckWait), b.deliverPolicy()}
+
+	if b.config.MaxAckPending != 0 {
+		opts = append(opts, nats.MaxAckPending(b.config.MaxAckPending))
+	}
+
 	if b.config.ConsumerGroup != "" {
-		sub, err = b.c.ChanQueueSubscribe(b.config.ConsumeSubject, b.config.ConsumerGroup, natsCh,
-			nats.ManualAck(), nats.AckWait(b.config.AckWait), nats.MaxDeliver(int(b.config.MaxRedeliveries)),
-			nats.DeliverNew())
+		opts = append(opts, nats.MaxDeliver(int(b.config.MaxRedeliveries)))
+		sub, err = b.c.ChanQueueSubscribe(b.config.ConsumeSubject, b.config.ConsumerGroup, natsCh, opts...)
 	} else {
-		sub, err = b.c.ChanSubscribe(b.config.ConsumeSubject, natsCh, nats.ManualAck(),
-			nats.AckWait(b.config.AckWait), nats.DeliverNew())
+		sub, err = b.c.ChanSubscribe(b.config.ConsumeSubject, natsCh, opts...)
 	}
 
 	if err != nil {
@@ -91,18 +145,10 @@ func (b *NatsJetStream) Sub() (<-chan Message, error) { //nolint:funlen,cyclop
 		for {
 			select {
 			case msg := <-natsCh:
-				messages <- Message{
-					Data: msg.Data,
-					Ack: func() {
-						if err := msg.Ack(); err != nil {
-							b.Debug(fmt.Sprintf("ack: %s", err))
-						}
-					},
-					InProgress: func() {
-						if err := msg.InProgress(); err != nil {
-							b.Debug(fmt.Sprintf("in progress: %s", err))
-						}
-					},
+				// Service stops reading once it's draining, message then stays unacknowledged to be redelivered.
+				select {
+				case messages <- b.message(msg):
+				case <-b.done:
 				}
 			case <-b.done:
 				defer b.wg.Done()
@@ -133,9 +179,74 @@ func (b *NatsJetStream) Sub() (<-chan Message, error) { //nolint:funlen,cyclop
 	return messages, nil
 }
 
+// deliverPolicy converts start position to NATS deliver policy.
+func (b *NatsJetStream) deliverPolicy() nats.SubOpt {
+	switch position := b.config.StartPosition; position.kind {
+	case startEarliest:
+		return nats.DeliverAll()
+	case startSequence:
+		return nats.StartSequence(position.sequence)
+	case startTime:
+		return nats.StartTime(position.time)
+	case startLatest:
+	}
+
+	return nats.DeliverNew()
+}
+
+// message converts NATS message to broker message.
+func (b *NatsJetStream) message(msg *nats.Msg) Message {
+	ack, nack := acknowledgeOnce(func() {
+		if err := msg.Ack(); err != nil {
+			b.Debug(fmt.Sprintf("ack: %s", err))
+		}
+	}, func() {
+		if err := msg.Nak(); err != nil {
+			b.Debug(fmt.Sprintf("nack: %s", err))
+		}
+	})
+
+	var id string
+
+	if meta, err := msg.Metadata(); err == nil {
+		id = fmt.Sprintf("%s:%d", meta.Stream, meta.Sequence.Stream)
+	}
+
+	return Message{ //nolint:exhaustruct
+		Data:    msg.Data,
+		headers: natsHeaders(msg.Header),
+		id:      id,
+		Ack:     ack,
+		Nack:    nack,
+		InProgress: func() {
+			if err := msg.InProgress(); err != nil {
+				b.Debug(fmt.Sprintf("in progress: %s", err))
+			}
+		},
+	}
+}
+
 // Pub implements broker.Broker interface.
-func (b *NatsJetStream) Pub(data []byte) error {
-	pub, err := b.c.Publish(b.config.ProduceSubject, data)
+func (b *NatsJetStream) Pub(data []byte, opts ...PubOption) error {
+	options := NewPubOptions(opts...)
+
+	msg := nats.NewMsg(b.config.ProduceSubject)
+	msg.Data = data
+
+	if options.Subject != "" {
+		msg.Subject = options.Subject
+	}
+
+	for key, value := range options.Headers {
+		msg.Header.Set(key, value)
+	}
+
+	if options.IdempotencyKey != "" {
+		// Stream drops messages with the same ID published within its duplicates window.
+		msg.Header.Set(nats.MsgIdHdr, options.IdempotencyKey)
+	}
+
+	pub, err := b.c.PublishMsg(msg)
 	if err != nil {
 		return fmt.Errorf("publish: %w", err)
 	}
@@ -145,8 +256,38 @@ func (b *NatsJetStream) Pub(data []byte) error {
 	return nil
 }
 
+// DeadLetter implements broker.DeadLetterer interface.
+func (b *NatsJetStream) DeadLetter(msg Message, reason error) error {
+	return PublishDeadLetter(b, b.config.DeadLetterSubject, msg, reason)
+}
+
+// Prefetch implements broker.Prefetcher interface.
+func (b *NatsJetStream) Prefetch() int {
+	return b.config.MaxAckPending
+}
+
 // Exit implements broker.Broker interface.
 func (b *NatsJetStream) Exit() {
 	close(b.done)
 	b.wg.Wait()
+
+	if b.nc != nil {
+		b.nc.Close()
+	}
+}
+
+func natsHeaders(header nats.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(header))
+
+	for key, values := range header {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+
+	return headers
 }
@@ -0,0 +1,299 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultPublishTimeout = 60 * time.Second
+	seekTimeout           = 60 * time.Second
+)
+
+// Errors.
+var (
+	ErrInsecureToken = errors.New("token requires TLS")
+)
+
+var (
+	_ Broker       = (*PubSub)(nil)
+	_ Prefetcher   = (*PubSub)(nil)
+	_ DeadLetterer = (*PubSub)(nil)
+)
+
+// PubSub implements Broker interface for Google Cloud Pub/Sub.
+// Exported field Debug can be used for debugging.
+type PubSub struct {
+	c      *pubsub.Client
+	config *PubSubConfig
+	topics map[string]*pubsub.Topic
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	// Whether the client was created by ConnectPubSub, so it's closed on Exit.
+	owned bool
+	Debug func(s string)
+}
+
+// PubSubConfig contains PubSub configuration parameters.
+type PubSubConfig struct {
+	// Google Cloud project, used by ConnectPubSub only. Default is to detect it from the credentials.
+	ProjectID string
+	// Consume this subscription
+	Subscription string
+	// Produce into this topic
+	Topic string
+	// MaxOutstandingMessages caps number of received but not yet acknowledged messages. Set it close to service
+	// concurrency to avoid prefetching more messages than workers can process. Default is client's default.
+	MaxOutstandingMessages int
+	// How long to wait for the server to confirm published message. Default 60s.
+	PublishTimeout time.Duration
+	// Where to start consuming. Pub/Sub can only seek to a time, which moves the subscription for all consumers,
+	// so use it for replays only. Default is StartLatest, which continues where the subscription left off.
+	StartPosition StartPosition
+	// Optional. If provided, messages failing permanently are published to this topic.
+	DeadLetterTopic string
+}
+
+// NewPubSub creates new Google Cloud Pub/Sub broker implementing broker.Broker interface.
+// Message keys map to Pub/Sub ordering keys, subscription has to have message ordering enabled to receive them.
+func NewPubSub(client *pubsub.Client, config *PubSubConfig) *PubSub {
+	if config.PublishTimeout == 0 {
+		config.PublishTimeout = defaultPublishTimeout
+	}
+
+	return &PubSub{ //nolint:exhaustruct
+		c:      client,
+		config: config,
+		topics: make(map[string]*pubsub.Topic),
+		cancel: func() {},
+		Debug:  func(string) {},
+	}
+}
+
+// ConnectPubSub creates Google Cloud Pub/Sub client using shared connection configuration and creates new Pub/Sub
+// broker. URL is the API endpoint, like pubsub.googleapis.com:443, and Token an OAuth2 access token used instead of
+// application default credentials. Without TLS the connection is plaintext and unauthenticated, as expected by
+// the emulator. Username and password are not supported. Created broker owns the client and closes it on Exit.
+func ConnectPubSub(conn *ConnConfig, config *PubSubConfig) (*PubSub, error) {
+	if err := conn.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	if conn.Username != "" {
+		return nil, fmt.Errorf("username: %w", ErrUnsupported)
+	}
+
+	if conn.Token != "" && conn.TLS == nil {
+		return nil, fmt.Errorf("config: %w", ErrInsecureToken)
+	}
+
+	opts := []option.ClientOption{option.WithEndpoint(conn.URL)}
+
+	if conn.TLS != nil {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(conn.TLS))))
+	} else {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithoutAuthentication())
+	}
+
+	if conn.Token != "" {
+		opts = append(opts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{ //nolint:exhaustruct
+			AccessToken: conn.Token,
+		})))
+	}
+
+	projectID := config.ProjectID
+	if projectID == "" {
+		projectID = pubsub.DetectProjectID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conn.ConnectTimeout)
+	defer cancel()
+
+	client, err := pubsub.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	b := NewPubSub(client, config)
+	b.owned = true
+
+	return b, nil
+}
+
+// Sub implements broker.Broker interface.
+func (b *PubSub) Sub() (<-chan Message, error) {
+	messages := make(chan Message)
+
+	sub := b.c.Subscription(b.config.Subscription)
+
+	if b.config.MaxOutstandingMessages != 0 {
+		sub.ReceiveSettings.MaxOutstandingMessages = b.config.MaxOutstandingMessages
+	}
+
+	if err := b.seek(sub); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(1)
+
+	go func() {
+		defer b.wg.Done()
+
+		// Receive returns only after all callbacks returned, so it's safe to close messages afterwards.
+		err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+			ack, nack := acknowledgeOnce(msg.Ack, msg.Nack)
+
+			select {
+			case messages <- Message{ //nolint:exhaustruct
+				Data:       msg.Data,
+				headers:    msg.Attributes,
+				key:        orderingKey(msg.OrderingKey),
+				id:         msg.ID,
+				Ack:        ack,
+				Nack:       nack,
+				InProgress: func() {}, // client extends ack deadline automatically
+			}:
+			case <-ctx.Done():
+				msg.Nack()
+			}
+		})
+		if err != nil {
+			select {
+			case messages <- NewErrMessage(fmt.Errorf("receive: %w", err)):
+			case <-ctx.Done():
+			}
+		}
+
+		b.Debug("stopping consumer")
+
+		close(messages)
+	}()
+
+	return messages, nil
+}
+
+// Pub implements broker.Broker interface.
+func (b *PubSub) Pub(data []byte, opts ...PubOption) error {
+	options := NewPubOptions(opts...)
+
+	topicID := b.config.Topic
+	if options.Subject != "" {
+		topicID = options.Subject
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.PublishTimeout)
+	defer cancel()
+
+	// Client may hold the message until the batch is sent, so data has to be copied.
+	msg := &pubsub.Message{ //nolint:exhaustruct
+		Data:        append([]byte(nil), data...),
+		Attributes:  options.Headers,
+		OrderingKey: string(options.Key),
+	}
+
+	topic := b.topic(topicID)
+
+	id, err := topic.Publish(ctx, msg).Get(ctx)
+	if err != nil {
+		if msg.OrderingKey != "" {
+			// Failed publish pauses the ordering key, following publishes would fail otherwise.
+			topic.ResumePublish(msg.OrderingKey)
+		}
+
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	b.Debug(fmt.Sprintf("publish topic: %s id: %s", topicID, id))
+
+	return nil
+}
+
+// DeadLetter implements broker.DeadLetterer interface.
+func (b *PubSub) DeadLetter(msg Message, reason error) error {
+	return PublishDeadLetter(b, b.config.DeadLetterTopic, msg, reason)
+}
+
+// Prefetch implements broker.Prefetcher interface.
+func (b *PubSub) Prefetch() int {
+	return b.config.MaxOutstandingMessages
+}
+
+// Exit implements broker.Broker interface.
+func (b *PubSub) Exit() {
+	b.cancel()
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range b.topics {
+		topic.Stop()
+	}
+
+	if !b.owned {
+		return
+	}
+
+	if err := b.c.Close(); err != nil {
+		b.Debug(fmt.Sprintf("close client: %v", err))
+	}
+}
+
+// seek moves subscription to the configured start position.
+func (b *PubSub) seek(sub *pubsub.Subscription) error {
+	switch position := b.config.StartPosition; position.kind {
+	case startLatest:
+		return nil
+	case startTime:
+		ctx, cancel := context.WithTimeout(context.Background(), seekTimeout)
+		defer cancel()
+
+		if err := sub.SeekToTime(ctx, position.time); err != nil {
+			return fmt.Errorf("seek: %w", err)
+		}
+
+		return nil
+	case startEarliest, startSequence:
+	}
+
+	return fmt.Errorf("start position: %w", ErrUnsupported)
+}
+
+// topic returns a topic handle, reusing it for subsequent publishes because each handle runs its own batching.
+func (b *PubSub) topic(id string) *pubsub.Topic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topic, ok := b.topics[id]
+	if !ok {
+		topic = b.c.Topic(id)
+		topic.EnableMessageOrdering = true
+		b.topics[id] = topic
+	}
+
+	return topic
+}
+
+// orderingKey converts Pub/Sub ordering key to message key.
+func orderingKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+
+	return []byte(key)
+}
@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestConnectPubSub(t *testing.T) {
+	t.Parallel()
+
+	srv := pstest.NewServer()
+	defer srv.Close()
+
+	b, err := ConnectPubSub(&ConnConfig{URL: srv.Addr}, &PubSubConfig{ //nolint:exhaustruct
+		ProjectID:    "oxeye",
+		Subscription: "in",
+		Topic:        "out",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Exit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	topic, err := b.c.CreateTopic(ctx, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := pubsub.SubscriptionConfig{Topic: topic} //nolint:exhaustruct
+
+	if _, err := b.c.CreateSubscription(ctx, "in", config); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := b.Sub()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Pub([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-messages:
+		if string(msg.Data) != "hello" {
+			t.Errorf("want hello, got %s", msg.Data)
+		}
+
+		msg.Ack()
+	case <-ctx.Done():
+		t.Fatal("want published message received")
+	}
+}
+
+func TestConnectPubSubRejectsUnsupportedAuth(t *testing.T) {
+	t.Parallel()
+
+	for name, test := range map[string]struct {
+		conn *ConnConfig
+		want error
+	}{
+		"username":          {conn: &ConnConfig{URL: "localhost:8085", Username: "u", Password: "p"}, want: ErrUnsupported},
+		"token without TLS": {conn: &ConnConfig{URL: "localhost:8085", Token: "t"}, want: ErrInsecureToken},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := ConnectPubSub(test.conn, &PubSubConfig{}); !errors.Is(err, test.want) { //nolint:exhaustruct
+				t.Errorf("want %v, got %v", test.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"errors"
+	"time"
+)
+
+// Errors.
+var (
+	ErrUnsupported = errors.New("unsupported by broker")
+)
+
+type startKind uint8
+
+const (
+	startLatest startKind = iota
+	startEarliest
+	startSequence
+	startTime
+)
+
+// StartPosition defines where a new subscription begins consuming. Zero value is StartLatest.
+type StartPosition struct {
+	kind     startKind
+	sequence uint64
+	time     time.Time
+}
+
+// StartLatest consumes only messages published after subscribing, or continues where the consumer left off if
+// the broker tracks it.
+func StartLatest() StartPosition {
+	return StartPosition{} //nolint:exhaustruct
+}
+
+// StartEarliest consumes all messages still retained by the broker.
+func StartEarliest() StartPosition {
+	return StartPosition{kind: startEarliest} //nolint:exhaustruct
+}
+
+// StartAtSequence consumes messages starting with the given broker specific sequence number or offset.
+func StartAtSequence(sequence uint64) StartPosition {
+	return StartPosition{kind: startSequence, sequence: sequence} //nolint:exhaustruct
+}
+
+// StartAtTime consumes messages published at or after the given time.
+func StartAtTime(t time.Time) StartPosition {
+	return StartPosition{kind: startTime, time: t} //nolint:exhaustruct
+}
+
+// IsEarliest reports whether the position was created by StartEarliest. Accessors of the position are meant for
+// brokers implemented outside this package.
+func (p StartPosition) IsEarliest() bool {
+	return p.kind == startEarliest
+}
+
+// Sequence returns sequence number of the position created by StartAtSequence.
+func (p StartPosition) Sequence() (uint64, bool) {
+	return p.sequence, p.kind == startSequence
+}
+
+// Time returns time of the position created by StartAtTime.
+func (p StartPosition) Time() (time.Time, bool) {
+	return p.time, p.kind == startTime
+}
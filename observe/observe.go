@@ -0,0 +1,195 @@
+// Package observe exposes service measurements as Prometheus metrics and serves health and readiness endpoints.
+package observe
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+)
+
+const readHeaderTimeout = 5 * time.Second
+
+var (
+	_ service.Metrics = (*Prometheus)(nil)
+	_ broker.Metrics  = (*Prometheus)(nil)
+)
+
+// Prometheus implements service.Metrics and broker.Metrics interfaces collecting measurements into own Prometheus
+// registry. Publishing is observed by both, so a broker decorated by broker.WithMetrics with the same instance
+// as the service counts each publish attempt besides the publish of the service.
+type Prometheus struct {
+	registry  *prometheus.Registry
+	consumed  prometheus.Counter
+	received  prometheus.Counter
+	processed *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	published *prometheus.CounterVec
+	workers   prometheus.Gauge
+	decode    *prometheus.HistogramVec
+	execute   *prometheus.HistogramVec
+	encode    *prometheus.HistogramVec
+	publish   prometheus.Histogram
+	ack       prometheus.Histogram
+	nack      prometheus.Histogram
+}
+
+// NewPrometheus creates metrics prefixed by namespace, registered together with Go runtime and process collectors.
+func NewPrometheus(namespace string) *Prometheus {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	histogram := func(stage string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      stage + "_duration_seconds",
+			Help:      "Time spent in " + stage + " stage of processed messages.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"worker"})
+	}
+
+	timing := func(operation string) prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      operation + "_duration_seconds",
+			Help:      "Time spent in " + operation + " calls of the broker.",
+			Buckets:   prometheus.DefBuckets,
+		})
+	}
+
+	metrics := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		consumed: prometheus.NewCounter(prometheus.CounterOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      "consumed_messages_total",
+			Help:      "Messages received from the broker.",
+		}),
+		received: prometheus.NewCounter(prometheus.CounterOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      "broker_received_messages_total",
+			Help:      "Messages received by brokers decorated with the metrics.",
+		}),
+		processed: counter("processed_messages_total", "Successfully processed messages.", "worker"),
+		failed:    counter("failed_messages_total", "Messages which failed to be processed.", "worker"),
+		published: counter("published_messages_total", "Published messages by result.", "result"),
+		workers: prometheus.NewGauge(prometheus.GaugeOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      "workers",
+			Help:      "Running workers.",
+		}),
+		decode:  histogram("decode"),
+		execute: histogram("execute"),
+		encode:  histogram("encode"),
+		publish: timing("publish"),
+		ack:     timing("ack"),
+		nack:    timing("nack"),
+	}
+
+	metrics.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}), //nolint:exhaustruct
+		metrics.consumed, metrics.processed, metrics.failed, metrics.published, metrics.workers,
+		metrics.decode, metrics.execute, metrics.encode,
+		metrics.received, metrics.publish, metrics.ack, metrics.nack,
+	)
+
+	return metrics
+}
+
+// Consumed implements service.Metrics interface.
+func (p *Prometheus) Consumed() {
+	p.consumed.Inc()
+}
+
+// Processed implements service.Metrics interface.
+func (p *Prometheus) Processed(workerID uint16, stats service.MessageStats) {
+	worker := strconv.Itoa(int(workerID))
+
+	p.processed.WithLabelValues(worker).Inc()
+	p.decode.WithLabelValues(worker).Observe(stats.Decode.Seconds())
+	p.execute.WithLabelValues(worker).Observe(stats.Execute.Seconds())
+	p.encode.WithLabelValues(worker).Observe(stats.Encode.Seconds())
+}
+
+// Failed implements service.Metrics interface.
+func (p *Prometheus) Failed(workerID uint16) {
+	p.failed.WithLabelValues(strconv.Itoa(int(workerID))).Inc()
+}
+
+// Published implements service.Metrics and broker.Metrics interfaces.
+func (p *Prometheus) Published(d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	p.published.WithLabelValues(result).Inc()
+	p.publish.Observe(d.Seconds())
+}
+
+// Received implements broker.Metrics interface.
+func (p *Prometheus) Received() {
+	p.received.Inc()
+}
+
+// Acked implements broker.Metrics interface.
+func (p *Prometheus) Acked(d time.Duration) {
+	p.ack.Observe(d.Seconds())
+}
+
+// Nacked implements broker.Metrics interface.
+func (p *Prometheus) Nacked(d time.Duration) {
+	p.nack.Observe(d.Seconds())
+}
+
+// Workers implements service.Metrics interface.
+func (p *Prometheus) Workers(workers uint16) {
+	p.workers.Set(float64(workers))
+}
+
+// Handler serves collected metrics in Prometheus exposition format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}) //nolint:exhaustruct
+}
+
+// Readiness is implemented by service.Service.
+type Readiness interface {
+	Ready() bool
+}
+
+// NewServer creates HTTP server listening on addr which serves metrics on /metrics, liveness on /healthz and
+// readiness on /readyz. Readiness responds with 503 Service Unavailable until ready reports true, for example
+// until the service subscribed to the broker. Server has to be started by the caller.
+func NewServer(addr string, metrics *Prometheus, ready Readiness) *http.Server {
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !ready.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	return &http.Server{ //nolint:exhaustruct
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
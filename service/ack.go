@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// WithManualAck leaves acknowledging of executed messages to the job, which gets the ack function of each message
+// by AckFrom, so it can acknowledge once asynchronous work handed off to background goroutines completed.
+// Messages failing to be processed are still settled by the service. Drain waits for pending acknowledgements
+// within the shutdown timeout, like for messages in flight, and reports messages never acknowledged in
+// ShutdownResult. Acknowledgements coming after that are ignored, so such messages are redelivered. It excludes
+// WithAckOnReceive and WithAckOnConfirm.
+func WithManualAck() Option {
+	return func(o *options) {
+		o.manualAck = true
+	}
+}
+
+// ackKey is context key of the ack function of the message in manual ack mode.
+type ackKey struct{}
+
+// AckFrom returns function acknowledging the message being processed, for jobs implementing ContextJob in
+// manual ack mode. Calls after the first one are no-op. It returns nil if ctx doesn't belong to an executed
+// message or if the service acknowledges messages on its own.
+func AckFrom(ctx context.Context) func() {
+	ack, _ := ctx.Value(ackKey{}).(func())
+
+	return ack
+}
+
+// manualAcks tracks messages left to be acknowledged by jobs.
+type manualAcks struct {
+	mu      sync.Mutex
+	pending int64
+	closed  bool
+	// Closed once there are no pending messages, replaced once there are again.
+	idle chan struct{}
+}
+
+func newManualAcks() *manualAcks {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &manualAcks{idle: idle} //nolint:exhaustruct
+}
+
+// manualAck is pending acknowledgement of a single message.
+type manualAck struct {
+	acks *manualAcks
+	msg  broker.Message
+	once sync.Once
+}
+
+// add registers message to be acknowledged by the job.
+func (a *manualAcks) add(msg broker.Message) *manualAck {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending++; a.pending == 1 {
+		a.idle = make(chan struct{})
+	}
+
+	return &manualAck{acks: a, msg: msg} //nolint:exhaustruct
+}
+
+// done removes pending message and reports whether it can still be acknowledged.
+func (a *manualAcks) done() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return false
+	}
+
+	if a.pending--; a.pending == 0 {
+		close(a.idle)
+	}
+
+	return true
+}
+
+// await waits until there are no pending messages or ctx is done. Later acknowledgements are ignored. It returns
+// number of messages never acknowledged.
+func (a *manualAcks) await(ctx context.Context) int64 {
+	a.mu.Lock()
+	idle := a.idle
+	a.mu.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+
+	return a.pending
+}
+
+// ack acknowledges the message unless shutdown gave up waiting for it.
+func (a *manualAck) ack() {
+	a.once.Do(func() {
+		if a.acks.done() {
+			a.msg.Ack()
+		}
+	})
+}
+
+// release stops waiting for acknowledgement of message the service settled on its own.
+func (a *manualAck) release() {
+	a.once.Do(func() { a.acks.done() })
+}
@@ -0,0 +1,128 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// handOffJob hands ack function of each message off, like to a background goroutine acknowledging once
+// asynchronous work completed.
+type handOffJob struct {
+	echoJob
+	acks chan func()
+}
+
+func (j handOffJob) ExecuteContext(ctx context.Context, msg *int) *int {
+	j.acks <- service.AckFrom(ctx)
+
+	return msg
+}
+
+// published waits until the broker has given number of published messages.
+func published(br *servicetest.Broker, messages int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if len(br.Published()) >= messages {
+			return
+		}
+	}
+}
+
+func TestManualAckLeavesAckToJob(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"), []byte("2"))
+	job := handOffJob{acks: make(chan func(), 2)} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, job, service.WithConcurrency(1), service.WithManualAck())
+
+	go func() {
+		published(br, 2)
+
+		if acked := br.Acked(); len(acked) != 0 {
+			t.Errorf("want no messages acknowledged by the service, got %v", acked)
+		}
+
+		for i := 0; i < 2; i++ {
+			ack := <-job.acks
+			ack()
+			ack()
+		}
+
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked := br.Acked(); len(acked) != 2 {
+		t.Errorf("want 2 messages acknowledged by the job, got %v", acked)
+	}
+
+	if result, _ := srv.ShutdownResult(); result.Unacked != 0 || result.TimedOut {
+		t.Errorf("want clean shutdown, got %+v", result)
+	}
+}
+
+func TestShutdownReportsUnackedMessages(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"))
+	job := handOffJob{acks: make(chan func(), 1)} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, job, service.WithConcurrency(1), service.WithManualAck(),
+		service.WithShutdownTimeout(10*time.Millisecond))
+
+	errs := make(chan error, 1)
+
+	go func() { errs <- srv.Run() }()
+
+	ack := <-job.acks
+	published(br, 1)
+
+	if err := srv.Shutdown(context.Background()); !errors.Is(err, service.ErrUncleanShutdown) {
+		t.Errorf("want %v, got %v", service.ErrUncleanShutdown, err)
+	}
+
+	if err := <-errs; !errors.Is(err, service.ErrUncleanShutdown) {
+		t.Errorf("want Run to return %v, got %v", service.ErrUncleanShutdown, err)
+	}
+
+	if result, _ := srv.ShutdownResult(); result.Unacked != 1 {
+		t.Errorf("want 1 unacknowledged message, got %+v", result)
+	}
+
+	ack()
+
+	if acked := br.Acked(); len(acked) != 0 {
+		t.Errorf("want acknowledgement after shutdown ignored, got %v", acked)
+	}
+}
+
+func TestAckFromIsNilWithoutManualAck(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"))
+	job := handOffJob{acks: make(chan func(), 1)} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, job, service.WithConcurrency(1))
+
+	go func() {
+		settled(br, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ack := <-job.acks; ack != nil {
+		t.Error("want no ack function when the service acknowledges messages")
+	}
+
+	if acked := br.Acked(); len(acked) != 1 {
+		t.Errorf("want message acknowledged by the service, got %v", acked)
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"hash/fnv"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Assignment is a strategy assigning messages to workers.
+type Assignment uint8
+
+// Assignment strategies.
+const (
+	// Shared lets all workers compete for messages from a single buffer. It suits stateless jobs best.
+	Shared Assignment = iota
+	// Hashed assigns messages with the same key always to the same worker, so jobs can keep per-key state like
+	// caches, and messages of each key are acknowledged in order, also during shutdown. Messages without key are
+	// assigned round-robin. Busy key blocks assigning messages to other workers
+	// once its worker's buffer is full.
+	Hashed
+	// RoundRobin assigns messages to workers in turn.
+	RoundRobin
+)
+
+// String implements fmt.Stringer interface.
+func (a Assignment) String() string {
+	switch a {
+	case Shared:
+		return "shared"
+	case Hashed:
+		return "hashed"
+	case RoundRobin:
+		return "round-robin"
+	default:
+		return "unknown"
+	}
+}
+
+// newAssigned creates buffer for each worker splitting the buffer size evenly, at least one message each.
+func newAssigned(concurrency uint16, bufferSize int) []chan broker.Message {
+	size := (bufferSize + int(concurrency) - 1) / int(concurrency)
+
+	buffers := make([]chan broker.Message, concurrency)
+
+	for i := range buffers {
+		buffers[i] = make(chan broker.Message, size)
+	}
+
+	return buffers
+}
+
+// bufferedAll returns number of messages in all buffers.
+func bufferedAll(buffers []chan broker.Message) int {
+	buffered := 0
+
+	for _, buffer := range buffers {
+		buffered += len(buffer)
+	}
+
+	return buffered
+}
+
+// forwardAssigned moves messages from the broker to buffers of workers chosen by the assignment strategy until
+// draining starts.
+func (s *Service[IN, OUT]) forwardAssigned(sub <-chan broker.Message, buffers []chan broker.Message,
+	flow *flowControl,
+) {
+	next := 0
+
+	// Message taken from the broker just as draining started is negatively acknowledged only after the buffered
+	// ones, so with hashed assignment acknowledgements of each key keep the order of its messages.
+	var rejected *broker.Message
+
+	defer func() {
+		s.nackLeftovers(buffers...)
+
+		if rejected != nil {
+			rejected.Nack()
+		}
+	}()
+
+	for s.consuming() {
+		var msg broker.Message
+
+		select {
+		case received, ok := <-sub:
+			if !ok {
+				s.subscriptionClosed()
+
+				return
+			}
+
+			msg = received
+		case <-s.done:
+			return
+		}
+
+		index := next
+
+		if key := msg.Key(); s.opts.assignment == Hashed && key != nil {
+			hash := fnv.New32a()
+			_, _ = hash.Write(key)
+			index = int(hash.Sum32() % uint32(len(buffers)))
+		} else {
+			next = (next + 1) % len(buffers)
+		}
+
+		select {
+		case buffers[index] <- msg:
+			flow.check(bufferedAll(buffers))
+		case <-s.done:
+			rejected = &msg
+
+			return
+		}
+	}
+}
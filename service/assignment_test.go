@@ -0,0 +1,146 @@
+package service_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// jitterJob executes in random time up to the given maximum.
+type jitterJob struct {
+	max time.Duration
+}
+
+func (j jitterJob) Execute(msg *int) *int {
+	time.Sleep(time.Duration(rand.Int63n(int64(j.max)))) //nolint:gosec
+
+	return msg
+}
+
+// keyedMessages creates messages with keys interleaved, so message at index i has key i modulo keys.
+func keyedMessages(count, keys int) *servicetest.Broker {
+	messages := make([][]byte, count)
+	messageKeys := make([][]byte, count)
+
+	for i := range messages {
+		messages[i] = []byte(strconv.Itoa(i))
+		messageKeys[i] = []byte(strconv.Itoa(i % keys))
+	}
+
+	return servicetest.NewBroker(messages...).WithKeys(messageKeys...)
+}
+
+// assertKeyOrder checks that acknowledgements of each key follow the order of its messages and that no
+// acknowledged message of a key follows a negatively acknowledged one.
+func assertKeyOrder(t *testing.T, br *servicetest.Broker, keys int) {
+	t.Helper()
+
+	lastAcked := make(map[int]int)
+
+	for _, index := range br.Acked() {
+		key := index % keys
+
+		if last, ok := lastAcked[key]; ok && last > index {
+			t.Errorf("key %d: message %d acknowledged after %d", key, index, last)
+		}
+
+		lastAcked[key] = index
+	}
+
+	for _, index := range br.Nacked() {
+		key := index % keys
+
+		if last, ok := lastAcked[key]; ok && last > index {
+			t.Errorf("key %d: message %d negatively acknowledged before acknowledged %d", key, index, last)
+		}
+	}
+}
+
+func TestHashedAssignmentKeepsKeyOrder(t *testing.T) {
+	t.Parallel()
+
+	const messages, keys = 200, 3
+
+	br := keyedMessages(messages, keys)
+	srv := service.NewService[int, int](br, jitterJob{max: time.Millisecond}, service.WithConcurrency(4),
+		service.WithAssignment(service.Hashed))
+
+	go func() {
+		settled(br, messages)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked := len(br.Acked()); acked != messages {
+		t.Errorf("want %d acknowledged messages, got %d", messages, acked)
+	}
+
+	assertKeyOrder(t, br, keys)
+}
+
+func TestHashedAssignmentKeepsKeyOrderOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	const messages, keys = 200, 3
+
+	br := keyedMessages(messages, keys)
+	srv := service.NewService[int, int](br, jitterJob{max: 2 * time.Millisecond}, service.WithConcurrency(4),
+		service.WithAssignment(service.Hashed), service.WithBufferSize(64))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(br.Nacked()) == 0 {
+		t.Error("want some messages negatively acknowledged on shutdown")
+	}
+
+	assertKeyOrder(t, br, keys)
+}
+
+func benchmarkAssignment(b *testing.B, assignment service.Assignment) {
+	b.Helper()
+
+	br := keyedMessages(b.N, 16)
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(4),
+		service.WithAssignment(assignment))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	go func() {
+		for len(br.Acked())+len(br.Nacked()) < b.N {
+			time.Sleep(time.Millisecond)
+		}
+
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkAssignmentShared(b *testing.B) {
+	benchmarkAssignment(b, service.Shared)
+}
+
+func BenchmarkAssignmentHashed(b *testing.B) {
+	benchmarkAssignment(b, service.Hashed)
+}
+
+func BenchmarkAssignmentRoundRobin(b *testing.B) {
+	benchmarkAssignment(b, service.RoundRobin)
+}
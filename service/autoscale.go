@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+const autoscaleChecks = 10
+
+// autoscaling contains bounds and hysteresis of dynamic number of workers.
+type autoscaling struct {
+	min            uint16
+	max            uint16
+	scaleUpAfter   time.Duration
+	scaleDownAfter time.Duration
+}
+
+// WithAutoscaling scales workers between minWorkers and maxWorkers instead of the fixed concurrency, which is
+// ignored. Service starts with minWorkers and adds a worker once messages kept waiting in the buffer with all
+// workers busy for scaleUpAfter. It removes a worker once the buffer stayed empty with some workers idle for
+// scaleDownAfter, so a longer scaleDownAfter keeps workers through short lulls. Workers are added and removed
+// one at a time. Default buffer size is maxWorkers. Autoscaling requires plain buffer with shared assignment.
+func WithAutoscaling(minWorkers, maxWorkers uint16, scaleUpAfter, scaleDownAfter time.Duration) Option {
+	return func(o *options) {
+		o.autoscaling = &autoscaling{
+			min:            minWorkers,
+			max:            maxWorkers,
+			scaleUpAfter:   scaleUpAfter,
+			scaleDownAfter: scaleDownAfter,
+		}
+	}
+}
+
+// validate checks autoscaling bounds and compatibility with other options. Nil autoscaling is valid.
+func (a *autoscaling) validate(o *options) error {
+	switch {
+	case a == nil:
+		return nil
+	case a.min == 0 || a.max < a.min:
+		return fmt.Errorf("%w: autoscaling requires 0 < min workers <= max workers", ErrInvalidConfig)
+	case a.scaleUpAfter <= 0 || a.scaleDownAfter <= 0:
+		return fmt.Errorf("%w: autoscaling requires positive scale up and down delays", ErrInvalidConfig)
+	case o.bufferSize == 0 || o.priority != nil || o.assignment != Shared:
+		return fmt.Errorf("%w: autoscaling requires plain buffer with %s assignment", ErrInvalidConfig, Shared)
+	}
+
+	return nil
+}
+
+// autoscaler starts and stops workers according to the backlog of the shared buffer.
+type autoscaler[IN, OUT any] struct {
+	service  *Service[IN, OUT]
+	config   *autoscaling
+	messages <-chan broker.Message
+	flow     *flowControl
+	// Channels stopping dynamically started workers, the last one belongs to the newest worker.
+	quit []chan struct{}
+	// Since when the scale up or down condition holds, zero if it doesn't.
+	backlogSince time.Time
+	idleSince    time.Time
+}
+
+// autoscale checks backlog of workers taking messages from the buffer until draining starts.
+func (s *Service[IN, OUT]) autoscale(messages <-chan broker.Message, flow *flowControl) {
+	scaler := &autoscaler[IN, OUT]{ //nolint:exhaustruct
+		service:  s,
+		config:   s.opts.autoscaling,
+		messages: messages,
+		flow:     flow,
+	}
+
+	interval := scaler.config.scaleUpAfter
+	if scaler.config.scaleDownAfter < interval {
+		interval = scaler.config.scaleDownAfter
+	}
+
+	ticker := time.NewTicker(interval / autoscaleChecks)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			scaler.check(now)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// check scales workers once the backlog or idleness lasted long enough.
+func (a *autoscaler[IN, OUT]) check(now time.Time) {
+	workers := a.workers()
+	inFlight := a.service.pool.stats.inFlight.Load()
+	backlog := len(a.messages) > 0 && inFlight >= int64(workers)
+	idle := len(a.messages) == 0 && inFlight < int64(workers)
+
+	a.backlogSince = since(a.backlogSince, backlog, now)
+	a.idleSince = since(a.idleSince, idle, now)
+
+	switch {
+	case backlog && workers < a.config.max && now.Sub(a.backlogSince) >= a.config.scaleUpAfter:
+		a.backlogSince = now
+
+		a.scaleUp(workers + 1)
+	case idle && workers > a.config.min && now.Sub(a.idleSince) >= a.config.scaleDownAfter:
+		a.idleSince = now
+
+		a.scaleDown(workers)
+	}
+}
+
+// workers returns current number of workers.
+func (a *autoscaler[IN, OUT]) workers() uint16 {
+	return a.config.min + uint16(len(a.quit))
+}
+
+// scaleUp starts worker with the given ID unless draining already started.
+func (a *autoscaler[IN, OUT]) scaleUp(workerID uint16) {
+	s := a.service
+	quit := make(chan struct{})
+
+	if !s.pool.spawn(func() { s.run(workerID, a.messages, a.flow, func() int { return len(a.messages) }, quit) }) {
+		return
+	}
+
+	a.quit = append(a.quit, quit)
+
+	s.Debug(fmt.Sprintf("scaling up to %d workers", workerID))
+	s.opts.metrics.Workers(workerID)
+}
+
+// scaleDown stops the newest worker, which has the given ID, once it finishes its current message.
+func (a *autoscaler[IN, OUT]) scaleDown(workerID uint16) {
+	last := len(a.quit) - 1
+
+	close(a.quit[last])
+	a.quit = a.quit[:last]
+
+	a.service.Debug(fmt.Sprintf("scaling down to %d workers", workerID-1))
+	a.service.opts.metrics.Workers(workerID - 1)
+}
+
+// since returns since when condition holds, zero time if it doesn't.
+func since(start time.Time, condition bool, now time.Time) time.Time {
+	switch {
+	case !condition:
+		return time.Time{}
+	case start.IsZero():
+		return now
+	default:
+		return start
+	}
+}
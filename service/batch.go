@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ProcessAll runs decode, execute and encode pipeline of the job over inputs without any broker, honoring the
+// concurrency, envelope, empty policy and execute timeout options of the service. It returns output and error for
+// each input at the same index. Output is nil if the job returned nil or processing failed. After ctx is done,
+// inputs not yet started fail with its error. All inputs fail with ErrInvalidConfig if concurrency is zero. It's
+// meant for table-driven tests of jobs and small backfills.
+func ProcessAll[IN, OUT any](ctx context.Context, job Job[IN, OUT], inputs [][]byte, opts ...Option) ([][]byte,
+	[]error,
+) {
+	options := newOptions(opts...)
+	outputs := make([][]byte, len(inputs))
+	errs := make([]error, len(inputs))
+
+	if err := options.validateConcurrency(); err != nil {
+		for index := range errs {
+			errs[index] = err
+		}
+
+		return outputs, errs
+	}
+
+	pool := NewPool(func(_ context.Context, index int) error {
+		if err := ctx.Err(); err != nil {
+			errs[index] = err
+
+			return nil
+		}
+
+		outputs[index], errs[index] = processOne(ctx, job, options, inputs[index])
+
+		return nil
+	}, opts...)
+
+	for index := range inputs {
+		if err := ctx.Err(); err != nil {
+			errs[index] = err
+
+			continue
+		}
+
+		_ = pool.Submit(index)
+	}
+
+	// Tasks are never abandoned, so results are not written after return.
+	_ = pool.Shutdown(context.Background())
+
+	return outputs, errs
+}
+
+// executeOne executes the job, passing ctx with execute timeout to jobs implementing ContextJob.
+func executeOne[IN, OUT any](ctx context.Context, job Job[IN, OUT], opts *options, in *IN) (*OUT, error) {
+	ctxJob, ok := job.(ContextJob[IN, OUT])
+	if !ok {
+		return job.Execute(in), nil
+	}
+
+	if opts.executeTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = opts.clock.WithTimeout(ctx, opts.executeTimeout)
+		defer cancel()
+	}
+
+	outMsg := ctxJob.ExecuteContext(ctx, in)
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execute: %w", err)
+	}
+
+	return outMsg, nil
+}
+
+// processOne decodes input, executes the job and encodes its output.
+func processOne[IN, OUT any](ctx context.Context, job Job[IN, OUT], opts *options, input []byte) ([]byte, error) {
+	var inMsg IN
+
+	in := &inMsg
+
+	switch {
+	case len(input) > 0:
+		if err := decodeMessage(opts, nil, input, &inMsg); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+	case opts.emptyPolicy == DeliverEmpty:
+		in = nil
+	case opts.emptyPolicy == FailEmpty:
+		return nil, ErrEmptyMessage
+	default:
+		return nil, nil //nolint:nilnil
+	}
+
+	outMsg, err := executeOne(ctx, job, opts, in)
+	if err != nil {
+		return nil, err
+	}
+
+	if outMsg == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	buf, err := encode(opts.encoder, outMsg)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	defer bufferPool.Put(buf)
+
+	return append([]byte(nil), buf.Bytes()...), nil
+}
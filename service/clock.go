@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells time to the service, so tests can control timeouts and backoff without sleeping, like
+// servicetest.Clock does.
+type Clock interface {
+	// Now returns current time.
+	Now() time.Time
+	// After sends current time on returned channel once the duration elapsed.
+	After(d time.Duration) <-chan time.Time
+	// WithTimeout returns copy of parent context canceled with context.DeadlineExceeded once the duration elapsed.
+	WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+// WithClock replaces the wall clock used for execute timeouts and backoff between retries. Nil clock is ignored.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// wallClock is Clock of the time package.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (wallClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, d)
+}
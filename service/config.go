@@ -0,0 +1,172 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Errors.
+var (
+	ErrMissingBroker = errors.New("missing broker")
+	ErrMissingJob    = errors.New("missing job")
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Config captures complete service deployment. Zero values mean defaults, same as omitted options.
+type Config[IN, OUT any] struct {
+	// Broker used to receive and publish messages.
+	Broker broker.Broker
+	// Optional broker used to publish messages instead of Broker.
+	OutputBroker broker.Broker
+	// Job executed for each message.
+	Job Job[IN, OUT]
+	// Number of workers. Default is number of CPUs.
+	Concurrency uint16
+	// Capacity of the internal buffer. Default is concurrency.
+	BufferSize int
+	// Disable internal buffer, workers then receive directly from the broker. Excludes buffer size and watermarks.
+	Unbuffered bool
+	// Number of buffered messages at which broker is paused. Default is buffer size.
+	HighWatermark int
+	// Number of buffered messages at which paused broker is resumed. Default is half of high watermark.
+	LowWatermark int
+	// Acknowledge input message only after the output has been published.
+	AckOnConfirm bool
+	// Preset of acknowledging, dedup and publish confirms. Default is to acknowledge after processing, even if
+	// publishing fails.
+	DeliveryGuarantee DeliveryGuarantee
+	// How long Drain waits for in-flight messages. Default is to wait until all messages are processed.
+	ShutdownTimeout time.Duration
+	// Deadline of context passed to jobs implementing ContextJob. Default is no deadline.
+	ExecuteTimeout time.Duration
+	// Number of retries of failed publish. Requires retry backoff.
+	PublishRetries uint
+	// Wait before the first publish retry, doubled for each following one. Requires publish retries.
+	RetryBackoff time.Duration
+	// Randomization of backoff between retries. Default is no jitter.
+	RetryJitter Jitter
+	// Negatively acknowledge messages failing to decode, encode or publish.
+	NackOnFailure bool
+}
+
+// Build validates configuration and creates the service.
+func (c *Config[IN, OUT]) Build() (*Service[IN, OUT], error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	opts := []Option{WithWatermarks(c.HighWatermark, c.LowWatermark)}
+
+	if c.BufferSize != 0 {
+		opts = append(opts, WithBufferSize(c.BufferSize))
+	}
+
+	if c.Unbuffered {
+		opts = append(opts, WithBufferSize(0))
+	}
+
+	if c.Concurrency != 0 {
+		opts = append(opts, WithConcurrency(c.Concurrency))
+	}
+
+	if c.AckOnConfirm {
+		opts = append(opts, WithAckOnConfirm())
+	}
+
+	if c.OutputBroker != nil {
+		opts = append(opts, WithOutputBroker(c.OutputBroker))
+	}
+
+	if c.DeliveryGuarantee != 0 {
+		opts = append(opts, WithDeliveryGuarantee(c.DeliveryGuarantee))
+	}
+
+	if c.ShutdownTimeout != 0 {
+		opts = append(opts, WithShutdownTimeout(c.ShutdownTimeout))
+	}
+
+	if c.ExecuteTimeout != 0 {
+		opts = append(opts, WithExecuteTimeout(c.ExecuteTimeout))
+	}
+
+	if c.PublishRetries != 0 {
+		opts = append(opts, WithPublishRetries(c.PublishRetries, c.RetryBackoff))
+	}
+
+	if c.RetryJitter != NoJitter {
+		opts = append(opts, WithJitter(c.RetryJitter))
+	}
+
+	if c.NackOnFailure {
+		opts = append(opts, WithNackOnFailure())
+	}
+
+	srv := NewService(c.Broker, c.Job, opts...)
+
+	if err := srv.opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return srv, nil
+}
+
+func (c *Config[IN, OUT]) validate() error {
+	if c.Broker == nil {
+		return ErrMissingBroker
+	}
+
+	if c.Job == nil {
+		return ErrMissingJob
+	}
+
+	if c.BufferSize < 0 {
+		return fmt.Errorf("%w: negative buffer size %d", ErrInvalidConfig, c.BufferSize)
+	}
+
+	if c.Unbuffered && (c.BufferSize != 0 || c.HighWatermark != 0 || c.LowWatermark != 0) {
+		return fmt.Errorf("%w: buffer size and watermarks require buffer", ErrInvalidConfig)
+	}
+
+	if c.HighWatermark < 0 || c.LowWatermark < 0 {
+		return fmt.Errorf("%w: negative watermark", ErrInvalidConfig)
+	}
+
+	if c.BufferSize != 0 && c.HighWatermark > c.BufferSize {
+		return fmt.Errorf("%w: high watermark %d exceeds buffer size %d", ErrInvalidConfig, c.HighWatermark,
+			c.BufferSize)
+	}
+
+	if c.HighWatermark != 0 && c.LowWatermark >= c.HighWatermark {
+		return fmt.Errorf("%w: low watermark %d has to be below high watermark %d", ErrInvalidConfig,
+			c.LowWatermark, c.HighWatermark)
+	}
+
+	if c.HighWatermark == 0 && c.LowWatermark != 0 {
+		return fmt.Errorf("%w: low watermark requires high watermark", ErrInvalidConfig)
+	}
+
+	return c.validateTimeouts()
+}
+
+func (c *Config[IN, OUT]) validateTimeouts() error {
+	if c.ShutdownTimeout < 0 || c.ExecuteTimeout < 0 {
+		return fmt.Errorf("%w: negative timeout", ErrInvalidConfig)
+	}
+
+	if c.RetryBackoff < 0 {
+		return fmt.Errorf("%w: negative retry backoff %s", ErrInvalidConfig, c.RetryBackoff)
+	}
+
+	if c.PublishRetries == 0 && c.RetryBackoff != 0 {
+		return fmt.Errorf("%w: retry backoff requires publish retries", ErrInvalidConfig)
+	}
+
+	if c.PublishRetries != 0 && c.RetryBackoff == 0 {
+		return fmt.Errorf("%w: publish retries require retry backoff", ErrInvalidConfig)
+	}
+
+	return nil
+}
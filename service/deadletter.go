@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// DrainDeadLetter consumes messages of a broker subscribed to the dead-letter destination, like NATS JetStream with
+// DeadLetterSubject as its subject, to triage or reprocess messages which failed permanently. Each message is
+// decoded by decoder, JSON if nil, and passed to handler with the reason from broker.DeadLetterReasonHeader, then
+// acknowledged. Message failing to decode is passed as nil with the decode error appended to the reason. It
+// returns once the subscription is closed, with error of ctx once it's done or with error reported by the broker,
+// and shuts down the broker in any case.
+func DrainDeadLetter[T any](ctx context.Context, br broker.Broker, decoder PayloadDecoder,
+	handler func(msg *T, reason string),
+) error {
+	if decoder == nil {
+		decoder = DecodeJSON
+	}
+
+	defer br.Exit()
+
+	messages, err := br.Sub()
+	if err != nil {
+		return fmt.Errorf("broker: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dead letter: %w", ctx.Err())
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			if err := msg.Err(); err != nil {
+				return fmt.Errorf("broker: %w", err)
+			}
+
+			reason := msg.Headers()[broker.DeadLetterReasonHeader]
+
+			var decoded T
+
+			if err := decoder(msg.Data, &decoded); err != nil {
+				handler(nil, fmt.Sprintf("%s (decode: %v)", reason, err))
+			} else {
+				handler(&decoded, reason)
+			}
+
+			msg.Ack()
+		}
+	}
+}
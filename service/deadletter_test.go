@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+func TestDrainDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"), []byte("invalid")).WithHeaders(
+		map[string]string{broker.DeadLetterReasonHeader: "execute: timeout"},
+		map[string]string{broker.DeadLetterReasonHeader: "decode: invalid"},
+	)
+
+	var (
+		messages []*int
+		reasons  []string
+	)
+
+	err := service.DrainDeadLetter(context.Background(), br, nil, func(msg *int, reason string) {
+		messages = append(messages, msg)
+		reasons = append(reasons, reason)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("want 2 handled messages, got %d", len(messages))
+	}
+
+	if messages[0] == nil || *messages[0] != 1 || reasons[0] != "execute: timeout" {
+		t.Errorf("want message 1 with its reason, got %v, %q", messages[0], reasons[0])
+	}
+
+	if messages[1] != nil || !strings.HasPrefix(reasons[1], "decode: invalid (decode: ") {
+		t.Errorf("want undecodable message with decode error, got %v, %q", messages[1], reasons[1])
+	}
+
+	if acked := br.Acked(); len(acked) != 2 {
+		t.Errorf("want all messages acknowledged, got %v", acked)
+	}
+}
+
+func TestDrainDeadLetterStopsOnContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := service.DrainDeadLetter(ctx, newOpenBroker(), nil, func(*int, string) {
+		t.Error("want no handled messages")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// dedup remembers hashes of processed messages within a time window, bounded by size.
+type dedup struct {
+	window time.Duration
+	size   int
+	mu     sync.Mutex
+	seen   map[[sha256.Size]byte]*list.Element
+	order  *list.List
+}
+
+type dedupEntry struct {
+	hash [sha256.Size]byte
+	at   time.Time
+}
+
+func newDedup(window time.Duration, size int) *dedup {
+	return &dedup{ //nolint:exhaustruct
+		window: window,
+		size:   size,
+		seen:   make(map[[sha256.Size]byte]*list.Element, size),
+		order:  list.New(),
+	}
+}
+
+// hash returns content hash of message data.
+func (d *dedup) hash(data []byte) [sha256.Size]byte {
+	return sha256.Sum256(data)
+}
+
+// contains checks if message with the hash has been processed within the window.
+func (d *dedup) contains(hash [sha256.Size]byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire(time.Now())
+
+	_, ok := d.seen[hash]
+
+	return ok
+}
+
+// add remembers processed message with the hash, evicting the oldest one if the cache is full.
+func (d *dedup) add(hash [sha256.Size]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	d.expire(now)
+
+	if elem, ok := d.seen[hash]; ok {
+		d.order.Remove(elem)
+	}
+
+	if d.order.Len() >= d.size {
+		d.remove(d.order.Front())
+	}
+
+	d.seen[hash] = d.order.PushBack(dedupEntry{hash: hash, at: now})
+}
+
+// expire removes entries older than the window.
+func (d *dedup) expire(now time.Time) {
+	for elem := d.order.Front(); elem != nil; elem = d.order.Front() {
+		entry, _ := elem.Value.(dedupEntry)
+		if now.Sub(entry.at) < d.window {
+			return
+		}
+
+		d.remove(elem)
+	}
+}
+
+func (d *dedup) remove(elem *list.Element) {
+	entry, _ := d.order.Remove(elem).(dedupEntry)
+	delete(d.seen, entry.hash)
+}
@@ -0,0 +1,113 @@
+package service_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+type echoJob struct{}
+
+func (echoJob) Execute(msg *int) *int {
+	return msg
+}
+
+// redeliveringBroker delivers messages without end, like a broker immediately redelivering negatively
+// acknowledged ones.
+type redeliveringBroker struct {
+	messages chan broker.Message
+	done     chan struct{}
+	nacked   atomic.Int64
+}
+
+func newRedeliveringBroker() *redeliveringBroker {
+	return &redeliveringBroker{messages: make(chan broker.Message), done: make(chan struct{})}
+}
+
+func (b *redeliveringBroker) Sub() (<-chan broker.Message, error) {
+	go func() {
+		for {
+			msg := broker.Message{ //nolint:exhaustruct
+				Data:       []byte("1"),
+				Ack:        func() {},
+				Nack:       func() { b.nacked.Add(1) },
+				InProgress: func() {},
+			}
+
+			select {
+			case b.messages <- msg:
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return b.messages, nil
+}
+
+func (b *redeliveringBroker) Pub([]byte, ...broker.PubOption) error { return nil }
+
+func (b *redeliveringBroker) Exit() {
+	close(b.done)
+}
+
+func TestDrainStopsReadingFromBroker(t *testing.T) {
+	t.Parallel()
+
+	for name, opts := range map[string][]service.Option{
+		"buffered":   {service.WithConcurrency(1)},
+		"unbuffered": {service.WithConcurrency(1), service.WithBufferSize(0)},
+		"priority":   {service.WithConcurrency(1), service.WithPriority(func(broker.Message) int { return 0 }, 0)},
+		"hashed":     {service.WithConcurrency(1), service.WithAssignment(service.Hashed)},
+	} {
+		opts := opts
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			br := newRedeliveringBroker()
+			srv := service.NewService[int, int](br, slowJob{delay: 100 * time.Millisecond}, opts...)
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				srv.Drain()
+			}()
+
+			if err := srv.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// At most buffered messages and the one being forwarded.
+			if nacked := br.nacked.Load(); nacked > 2 {
+				t.Errorf("want at most 2 negatively acknowledged messages, got %d", nacked)
+			}
+		})
+	}
+}
+
+func TestClosedSubscriptionProcessesBufferedMessages(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"), []byte("2"))
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1))
+
+	go func() {
+		for deadline := time.Now().Add(time.Second); len(br.Acked()) < 2 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked, nacked := br.Acked(), br.Nacked(); len(acked) != 2 || len(nacked) != 0 {
+		t.Errorf("want both messages acknowledged, got acked %v and nacked %v", acked, nacked)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+// EmptyPolicy defines handling of zero-length messages.
+type EmptyPolicy uint8
+
+// Empty message policies.
+const (
+	// SkipEmpty acknowledges empty message without executing the job.
+	SkipEmpty EmptyPolicy = iota
+	// FailEmpty treats empty message as failed with ErrEmptyMessage, handled the same as messages failing to decode.
+	FailEmpty
+	// DeliverEmpty executes the job with nil input as a tombstone marker.
+	DeliverEmpty
+)
+
+// String implements fmt.Stringer interface.
+func (p EmptyPolicy) String() string {
+	switch p {
+	case SkipEmpty:
+		return "skip"
+	case FailEmpty:
+		return "fail"
+	case DeliverEmpty:
+		return "deliver"
+	default:
+		return "unknown"
+	}
+}
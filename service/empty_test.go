@@ -0,0 +1,69 @@
+package service_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// tombstoneJob counts executions with nil input.
+type tombstoneJob struct {
+	executed   atomic.Int64
+	tombstones atomic.Int64
+}
+
+func (j *tombstoneJob) Execute(msg *int) *int {
+	j.executed.Add(1)
+
+	if msg == nil {
+		j.tombstones.Add(1)
+	}
+
+	return nil
+}
+
+func TestEmptyMessage(t *testing.T) {
+	t.Parallel()
+
+	tests := map[service.EmptyPolicy]struct {
+		acked, nacked, executed, tombstones int
+	}{
+		service.SkipEmpty:    {acked: 1, nacked: 0, executed: 0, tombstones: 0},
+		service.FailEmpty:    {acked: 0, nacked: 1, executed: 0, tombstones: 0},
+		service.DeliverEmpty: {acked: 1, nacked: 0, executed: 1, tombstones: 1},
+	}
+
+	for policy, want := range tests {
+		policy, want := policy, want
+
+		t.Run(policy.String(), func(t *testing.T) {
+			t.Parallel()
+
+			br := servicetest.NewBroker([]byte{})
+			job := &tombstoneJob{} //nolint:exhaustruct
+			srv := service.NewService[int, int](br, job, service.WithConcurrency(1), service.WithEmptyPolicy(policy),
+				service.WithNackOnFailure())
+
+			go func() {
+				settled(br, 1)
+				srv.Drain()
+			}()
+
+			if err := srv.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			if acked, nacked := len(br.Acked()), len(br.Nacked()); acked != want.acked || nacked != want.nacked {
+				t.Errorf("want %d acked and %d nacked, got %d and %d", want.acked, want.nacked, acked, nacked)
+			}
+
+			if executed, tombstones := job.executed.Load(), job.tombstones.Load(); executed != int64(want.executed) ||
+				tombstones != int64(want.tombstones) {
+				t.Errorf("want %d executions with %d tombstones, got %d and %d", want.executed, want.tombstones,
+					executed, tombstones)
+			}
+		})
+	}
+}
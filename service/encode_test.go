@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type benchmarkMessage struct {
+	ID     string            `json:"id"`
+	Count  int               `json:"count"`
+	Tags   []string          `json:"tags"`
+	Labels map[string]string `json:"labels"`
+}
+
+func newBenchmarkMessage() *benchmarkMessage {
+	return &benchmarkMessage{
+		ID:     "0b8f3c1e-6a57-4d8e-9f61-2f4d3c9a7e10",
+		Count:  42,
+		Tags:   []string{"alpha", "beta", "gamma"},
+		Labels: map[string]string{"tenant": "acme", "region": "eu-west-1"},
+	}
+}
+
+func BenchmarkEncodePooled(b *testing.B) {
+	msg := newBenchmarkMessage()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		buf, err := encode(EncodeJSON, msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		bufferPool.Put(buf)
+	}
+}
+
+func BenchmarkEncodeMarshal(b *testing.B) {
+	msg := newBenchmarkMessage()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Errors.
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// ContentTypeHeader is message header declaring format of the message, used to select decoder registered by
+// WithContentTypes.
+const ContentTypeHeader = "content-type"
+
+// Content types of formats with decoder and encoder provided by the package.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// PayloadDecoder decodes message payload into v.
+type PayloadDecoder func(payload []byte, v any) error
+
+// PayloadEncoder encodes v and appends it to buf.
+type PayloadEncoder func(buf *bytes.Buffer, v any) error
+
+// EnvelopeDecoder decodes envelope of messages which declare format of their payload, like schema-on-read
+// envelopes carrying metadata in a stable format and payload in varying one.
+type EnvelopeDecoder interface {
+	// DecodeEnvelope returns payload of the message and decoder for the payload format declared by the envelope.
+	DecodeEnvelope(data []byte) (payload []byte, decoder PayloadDecoder, err error)
+}
+
+// DecodeJSON is PayloadDecoder for JSON payloads, the same as used by the service for messages without envelope.
+func DecodeJSON(payload []byte, v any) error {
+	return decode(payload, v)
+}
+
+// DecodeProtobuf is PayloadDecoder for protobuf payloads. Input type of the job has to be generated protobuf
+// message, so v implements proto.Message.
+func DecodeProtobuf(payload []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T is not protobuf message", ErrInvalidMessageType, v)
+	}
+
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+
+	return nil
+}
+
+// DecodeMsgpack is PayloadDecoder for MessagePack payloads.
+func DecodeMsgpack(payload []byte, v any) error {
+	if err := msgpack.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+
+	return nil
+}
+
+// EncodeJSON is PayloadEncoder for JSON, the default one for output.
+func EncodeJSON(buf *bytes.Buffer, v any) error {
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	// json.Encoder terminates each value with a newline, json.Marshal doesn't.
+	buf.Truncate(buf.Len() - 1)
+
+	return nil
+}
+
+// EncodeProtobuf is PayloadEncoder for protobuf. Output type of the job has to be generated protobuf message.
+func EncodeProtobuf(buf *bytes.Buffer, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T is not protobuf message", ErrInvalidMessageType, v)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("protobuf: %w", err)
+	}
+
+	buf.Write(data)
+
+	return nil
+}
+
+// EncodeMsgpack is PayloadEncoder for MessagePack.
+func EncodeMsgpack(buf *bytes.Buffer, v any) error {
+	if err := msgpack.NewEncoder(buf).Encode(v); err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+
+	return nil
+}
+
+// decodeMessage decodes message data into v, unwrapping the envelope first if there is envelope decoder, or by
+// decoder registered for content type declared in headers.
+func decodeMessage(opts *options, headers map[string]string, data []byte, v any) error {
+	if opts.contentTypes != nil {
+		return decodeContentType(opts.contentTypes, headers, data, v)
+	}
+
+	if opts.envelope == nil {
+		return decode(data, v)
+	}
+
+	payload, decoder, err := opts.envelope.DecodeEnvelope(data)
+	if err != nil {
+		return fmt.Errorf("envelope: %w", err)
+	}
+
+	if err := decoder(payload, v); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+
+	return nil
+}
+
+// decodeContentType decodes data by decoder registered for ContentTypeHeader. Messages without the header are
+// decoded as JSON.
+func decodeContentType(decoders map[string]PayloadDecoder, headers map[string]string, data []byte, v any) error {
+	contentType, ok := headers[ContentTypeHeader]
+	if !ok {
+		return decode(data, v)
+	}
+
+	decoder, ok := decoders[contentType]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+
+	if err := decoder(data, v); err != nil {
+		return fmt.Errorf("%s: %w", contentType, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// lengthJob returns length of the string.
+type lengthJob struct{}
+
+func (lengthJob) Execute(msg *wrapperspb.StringValue) *int {
+	length := len(msg.GetValue())
+
+	return &length
+}
+
+func TestContentTypeSelectsDecoder(t *testing.T) {
+	t.Parallel()
+
+	protobuf, err := proto.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br := servicetest.NewBroker(protobuf, []byte(`{"value":"hi"}`), []byte("hello")).WithHeaders(
+		map[string]string{service.ContentTypeHeader: "application/protobuf"},
+		nil,
+		map[string]string{service.ContentTypeHeader: "text/plain"},
+	)
+	srv := service.NewService[wrapperspb.StringValue, int](br, lengthJob{}, service.WithConcurrency(1),
+		service.WithNackOnFailure(),
+		service.WithContentTypes(map[string]service.PayloadDecoder{"application/protobuf": service.DecodeProtobuf}))
+
+	go func() {
+		settled(br, 3)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if nacked := br.Nacked(); len(nacked) != 1 || nacked[0] != 2 {
+		t.Errorf("want message with unsupported content type negatively acknowledged, got %v", nacked)
+	}
+
+	published := br.Published()
+	if len(published) != 2 {
+		t.Fatalf("want 2 published messages, got %d", len(published))
+	}
+
+	for i, want := range []string{"5", "2"} {
+		if got := string(published[i].Data); got != want {
+			t.Errorf("message %d: want %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestOutputContentTypeSelectsEncoder(t *testing.T) {
+	t.Parallel()
+
+	packed, err := msgpack.Marshal(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br := servicetest.NewBroker(packed, []byte("7")).WithHeaders(
+		map[string]string{service.ContentTypeHeader: service.ContentTypeMsgpack},
+		nil,
+	)
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1),
+		service.WithContentTypes(map[string]service.PayloadDecoder{service.ContentTypeMsgpack: service.DecodeMsgpack}),
+		service.WithOutputContentType(service.ContentTypeMsgpack, service.EncodeMsgpack))
+
+	go func() {
+		settled(br, 2)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	published := br.Published()
+	if len(published) != 2 {
+		t.Fatalf("want 2 published messages, got %d", len(published))
+	}
+
+	for i, want := range []int{21, 7} {
+		var got int
+		if err := msgpack.Unmarshal(published[i].Data, &got); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if got != want {
+			t.Errorf("message %d: want %d, got %d", i, want, got)
+		}
+
+		if got := published[i].Options.Headers[service.ContentTypeHeader]; got != service.ContentTypeMsgpack {
+			t.Errorf("message %d: want content type %s, got %q", i, service.ContentTypeMsgpack, got)
+		}
+	}
+}
+
+func TestUnsupportedContentTypeIsDeadLettered(t *testing.T) {
+	t.Parallel()
+
+	br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("1")).WithHeaders( //nolint:exhaustruct
+		map[string]string{service.ContentTypeHeader: "text/plain"},
+	)}
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1),
+		service.WithContentTypes(map[string]service.PayloadDecoder{service.ContentTypeMsgpack: service.DecodeMsgpack}))
+
+	go func() {
+		settled(br.Broker, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if deadLettered := br.deadLettered.Load(); deadLettered != 1 {
+		t.Errorf("want message dead-lettered, got %d dead-letters", deadLettered)
+	}
+
+	if acked, nacked := br.Acked(), br.Nacked(); len(acked) != 1 || len(nacked) != 0 {
+		t.Errorf("want dead-lettered message acknowledged only, got acked %v, nacked %v", acked, nacked)
+	}
+
+	if published := br.Published(); len(published) != 0 {
+		t.Errorf("want no published messages, got %d", len(published))
+	}
+}
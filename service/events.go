@@ -0,0 +1,61 @@
+package service
+
+import "time"
+
+// EventType identifies lifecycle event.
+type EventType uint8
+
+// Lifecycle events.
+const (
+	WorkerStarted EventType = iota + 1
+	WorkerStopped
+	MessageProcessed
+	MessageFailed
+	ShutdownInitiated
+)
+
+// String implements fmt.Stringer interface.
+func (t EventType) String() string {
+	switch t {
+	case WorkerStarted:
+		return "worker started"
+	case WorkerStopped:
+		return "worker stopped"
+	case MessageProcessed:
+		return "message processed"
+	case MessageFailed:
+		return "message failed"
+	case ShutdownInitiated:
+		return "shutdown initiated"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something that happened in the service.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Worker which emitted the event, zero for service-wide events.
+	WorkerID uint16
+	// Reason of MessageFailed event.
+	Err error
+}
+
+// Events returns channel streaming lifecycle events if enabled by WithEvents, otherwise nil. Channel is closed
+// once the service is drained.
+func (s *Service[IN, OUT]) Events() <-chan Event {
+	return s.events
+}
+
+// emit sends event without blocking. Event is dropped if nobody keeps up with reading the events.
+func (s *Service[IN, OUT]) emit(eventType EventType, workerID uint16, err error) {
+	if s.events == nil {
+		return
+	}
+
+	select {
+	case s.events <- Event{Type: eventType, Time: time.Now(), WorkerID: workerID, Err: err}:
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// extendingBroker delivers messages supporting ack deadline extension and records requested deadlines.
+type extendingBroker struct {
+	*servicetest.Broker
+	mu       sync.Mutex
+	extended []time.Duration
+}
+
+func (b *extendingBroker) Sub() (<-chan broker.Message, error) {
+	sub, err := b.Broker.Sub()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	messages := make(chan broker.Message, len(sub))
+
+	for msg := range sub {
+		msg.Extend = func(d time.Duration) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.extended = append(b.extended, d)
+		}
+
+		messages <- msg
+	}
+
+	close(messages)
+
+	return messages, nil
+}
+
+// extendJob extends ack deadline of each message it executes.
+type extendJob struct {
+	echoJob
+	deadline time.Duration
+}
+
+func (j extendJob) ExecuteContext(ctx context.Context, msg *int) *int {
+	service.ExtendAck(ctx, j.deadline)
+
+	return msg
+}
+
+func TestExtendAckSetsDeadline(t *testing.T) {
+	t.Parallel()
+
+	br := &extendingBroker{Broker: servicetest.NewBroker([]byte("1"))} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, extendJob{deadline: time.Minute}, service.WithConcurrency(1))
+
+	go func() {
+		settled(br.Broker, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if len(br.extended) != 1 || br.extended[0] != time.Minute {
+		t.Errorf("want deadline extended once by %s, got %v", time.Minute, br.extended)
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// fail settles message which failed permanently. It's dead-lettered if the broker supports it, otherwise
+// negatively acknowledged if enabled by WithNackOnFailure. It reports whether the message was settled.
+func (s *Service[IN, OUT]) fail(workerID uint16, msg broker.Message, reason error, logCtx string) bool {
+	if deadLetterer, ok := s.broker.(broker.DeadLetterer); ok {
+		err := deadLetterer.DeadLetter(msg, reason)
+		if err == nil {
+			s.Debug(fmt.Sprintf("worker %d dead-lettered message%s", workerID, logCtx))
+			msg.Ack()
+
+			return true
+		}
+
+		if !errors.Is(err, broker.ErrUnsupported) {
+			s.Debug(fmt.Sprintf("worker %d dead-lettering message: %v%s", workerID, err, logCtx))
+		}
+	}
+
+	if s.opts.nackOnFailure {
+		msg.Nack()
+
+		return true
+	}
+
+	return false
+}
+
+// pub publishes message, retrying failed attempts with exponential backoff if enabled by WithPublishRetries.
+// Retrying stops once shutdown started.
+func (s *Service[IN, OUT]) pub(data []byte, opts []broker.PubOption) error {
+	backoff := newBackoff(s.opts.jitter, s.opts.retryBackoff, maxReadinessBackoff)
+	start := time.Now()
+
+	for attempt := uint(0); ; attempt++ {
+		err := s.output.Pub(data, opts...)
+		if err == nil || attempt >= s.opts.publishRetries {
+			s.opts.metrics.Published(time.Since(start), err)
+
+			return err //nolint:wrapcheck
+		}
+
+		wait := backoff.next()
+
+		s.Debug(fmt.Sprintf("publish attempt %d: %v, retrying in %s", attempt+1, err, wait))
+
+		select {
+		case <-s.opts.clock.After(wait):
+		case <-s.done:
+			s.opts.metrics.Published(time.Since(start), err)
+
+			return err //nolint:wrapcheck
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+type deadLetterBroker struct {
+	*servicetest.Broker
+	deadLettered atomic.Int64
+}
+
+func (b *deadLetterBroker) DeadLetter(broker.Message, error) error {
+	b.deadLettered.Add(1)
+
+	return nil
+}
+
+// settled waits until all messages of the broker were acknowledged or negatively acknowledged.
+func settled(br *servicetest.Broker, messages int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if len(br.Acked())+len(br.Nacked()) >= messages {
+			return
+		}
+	}
+}
+
+func TestFailedMessageIsDeadLettered(t *testing.T) {
+	t.Parallel()
+
+	br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("invalid"))} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1))
+
+	go func() {
+		settled(br.Broker, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked, deadLettered := br.Acked(), br.deadLettered.Load(); len(acked) != 1 || deadLettered != 1 {
+		t.Errorf("want message dead-lettered and acknowledged, got %d dead-lettered and acked %v", deadLettered,
+			acked)
+	}
+}
+
+func TestTimedOutMessageIsNotDeadLettered(t *testing.T) {
+	t.Parallel()
+
+	br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("1"), []byte("2"))} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, slowJob{delay: time.Second}, service.WithConcurrency(2),
+		service.WithExecuteTimeout(10*time.Millisecond))
+
+	go func() {
+		settled(br.Broker, 2)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if nacked, deadLettered := br.Nacked(), br.deadLettered.Load(); len(nacked) != 2 || deadLettered != 0 {
+		t.Errorf("want messages negatively acknowledged, got %d dead-lettered and nacked %v", deadLettered, nacked)
+	}
+}
+
+// poisonBroker redelivers its only message whenever it's negatively acknowledged, like Kafka does, until it's
+// dead-lettered.
+type poisonBroker struct {
+	messages     chan broker.Message
+	nacked       atomic.Int64
+	deadLettered chan struct{}
+}
+
+func newPoisonBroker() *poisonBroker {
+	b := &poisonBroker{messages: make(chan broker.Message, 1), deadLettered: make(chan struct{})} //nolint:exhaustruct
+	b.deliver()
+
+	return b
+}
+
+func (b *poisonBroker) deliver() {
+	b.messages <- broker.Message{ //nolint:exhaustruct
+		Data: []byte("1"),
+		Ack:  func() {},
+		Nack: func() {
+			b.nacked.Add(1)
+			b.deliver()
+		},
+		InProgress: func() {},
+	}.WithID("poison")
+}
+
+func (b *poisonBroker) Sub() (<-chan broker.Message, error) { return b.messages, nil }
+
+func (b *poisonBroker) Pub([]byte, ...broker.PubOption) error { return nil }
+
+func (b *poisonBroker) DeadLetter(broker.Message, error) error {
+	close(b.deadLettered)
+
+	return nil
+}
+
+func (b *poisonBroker) Exit() {}
+
+func TestRepeatedlyTimedOutMessageIsDeadLettered(t *testing.T) {
+	t.Parallel()
+
+	br := newPoisonBroker()
+	srv := service.NewService[int, int](br, slowJob{delay: time.Second}, service.WithConcurrency(1),
+		service.WithExecuteTimeout(time.Millisecond), service.WithTimeoutAttempts(3))
+
+	go func() {
+		select {
+		case <-br.deadLettered:
+		case <-time.After(time.Second):
+			t.Error("want message dead-lettered after timeout attempts")
+		}
+
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if nacked := br.nacked.Load(); nacked != 2 {
+		t.Errorf("want message retried twice before dead-lettering, got %d nacks", nacked)
+	}
+}
+
+// flakyBroker fails given number of publish attempts before publishing.
+type flakyBroker struct {
+	*servicetest.Broker
+	failures atomic.Int64
+}
+
+func (b *flakyBroker) Pub(data []byte, opts ...broker.PubOption) error {
+	if b.failures.Add(-1) >= 0 {
+		return errPublish
+	}
+
+	return b.Broker.Pub(data, opts...) //nolint:wrapcheck
+}
+
+var errPublish = errors.New("publish failed")
+
+func TestEmitRetriesPublish(t *testing.T) {
+	t.Parallel()
+
+	br := &flakyBroker{Broker: servicetest.NewBroker()} //nolint:exhaustruct
+	br.failures.Store(2)
+
+	srv := service.NewService[int, int](br, echoJob{}, service.WithPublishRetries(2, time.Millisecond))
+
+	if err := srv.Emit(context.Background(), "audit", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if published := br.Published(); len(published) != 1 || published[0].Options.Subject != "audit" {
+		t.Errorf("want message published to audit, got %v", published)
+	}
+}
+
+// auditJob emits audit event of each message through the emitter of its context.
+type auditJob struct {
+	echoJob
+}
+
+func (auditJob) ExecuteContext(ctx context.Context, msg *int) *int {
+	if err := service.EmitterFrom(ctx).Emit(ctx, "audit", *msg*10); err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+func TestJobEmitsThroughContext(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("4"))
+	srv := service.NewService[int, int](br, auditJob{}, service.WithConcurrency(1))
+
+	go func() {
+		settled(br, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	published := br.Published()
+	if len(published) != 2 {
+		t.Fatalf("want audit event and output published, got %d messages", len(published))
+	}
+
+	if audit := published[0]; audit.Options.Subject != "audit" || string(audit.Data) != "40" {
+		t.Errorf("want 40 published to audit, got %s to %q", audit.Data, audit.Options.Subject)
+	}
+
+	if output := published[1]; output.Options.Subject != "" || string(output.Data) != "4" {
+		t.Errorf("want 4 published to default topic, got %s to %q", output.Data, output.Options.Subject)
+	}
+
+	if emitter := service.EmitterFrom(context.Background()); emitter != nil {
+		t.Errorf("want no emitter outside of job, got %v", emitter)
+	}
+}
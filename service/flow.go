@@ -0,0 +1,52 @@
+package service
+
+import (
+	"sync"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// flowControl pauses brokers implementing broker.Pauser when internal buffer reaches high watermark and resumes
+// them once it drains to low watermark. Other brokers are throttled just by blocking on the full buffer.
+type flowControl struct {
+	pauser broker.Pauser
+	high   int
+	low    int
+	mu     sync.Mutex
+	paused bool
+	debug  func(s string)
+}
+
+func newFlowControl(br broker.Broker, high, low int, debug func(s string)) *flowControl {
+	pauser, _ := br.(broker.Pauser)
+
+	return &flowControl{ //nolint:exhaustruct
+		pauser: pauser,
+		high:   high,
+		low:    low,
+		debug:  debug,
+	}
+}
+
+// check compares number of buffered messages against watermarks. It's no-op on nil flow control.
+func (f *flowControl) check(buffered int) {
+	if f == nil || f.pauser == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case !f.paused && buffered >= f.high:
+		f.paused = true
+
+		f.debug("buffer reached high watermark, pausing broker")
+		f.pauser.Pause()
+	case f.paused && buffered <= f.low:
+		f.paused = false
+
+		f.debug("buffer reached low watermark, resuming broker")
+		f.pauser.Resume()
+	}
+}
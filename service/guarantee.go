@@ -0,0 +1,52 @@
+package service
+
+import "time"
+
+const (
+	effectivelyOnceDedupWindow = 10 * time.Minute
+	effectivelyOnceDedupSize   = 10000
+)
+
+// DeliveryGuarantee describes how many times a message takes effect if the service or the broker fails.
+type DeliveryGuarantee uint8
+
+// Delivery guarantees.
+const (
+	// AtMostOnce acknowledges messages on receive, failed messages are lost but never processed twice.
+	AtMostOnce DeliveryGuarantee = iota + 1
+	// AtLeastOnce acknowledges messages only after the output was confirmed by the broker, failed messages are
+	// redelivered and may be processed twice.
+	AtLeastOnce
+	// EffectivelyOnce is AtLeastOnce with dedup of messages redelivered within 10 minutes, the last 10000 messages
+	// at most, and outputs published with idempotency keys.
+	EffectivelyOnce
+)
+
+// String implements fmt.Stringer interface.
+func (g DeliveryGuarantee) String() string {
+	switch g {
+	case AtMostOnce:
+		return "at most once"
+	case AtLeastOnce:
+		return "at least once"
+	case EffectivelyOnce:
+		return "effectively once"
+	default:
+		return "unknown"
+	}
+}
+
+// apply sets options making up the guarantee.
+func (g DeliveryGuarantee) apply(o *options) {
+	switch g {
+	case AtMostOnce:
+		o.ackOnReceive = true
+	case AtLeastOnce:
+		o.ackOnConfirm = true
+	case EffectivelyOnce:
+		o.ackOnConfirm = true
+		o.dedupWindow = effectivelyOnceDedupWindow
+		o.dedupSize = effectivelyOnceDedupSize
+		o.idempotencyKey = IdempotencyKey
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Jitter is a strategy randomizing backoff between retries, so instances failing at once don't retry in sync.
+type Jitter uint8
+
+// Jitter strategies.
+const (
+	// NoJitter waits the whole backoff, doubled for each following retry.
+	NoJitter Jitter = iota
+	// FullJitter waits random duration between zero and the backoff.
+	FullJitter
+	// DecorrelatedJitter waits random duration between the initial backoff and three times the previous wait.
+	DecorrelatedJitter
+)
+
+// String implements fmt.Stringer interface.
+func (j Jitter) String() string {
+	switch j {
+	case NoJitter:
+		return "none"
+	case FullJitter:
+		return "full"
+	case DecorrelatedJitter:
+		return "decorrelated"
+	default:
+		return "unknown"
+	}
+}
+
+// WithJitter randomizes backoff of publish retries, readiness check and warmup. Default is NoJitter.
+func WithJitter(jitter Jitter) Option {
+	return func(o *options) {
+		o.jitter = jitter
+	}
+}
+
+// backoff computes waits between retries starting at initial one and capped at the maximum.
+type backoff struct {
+	jitter  Jitter
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(jitter Jitter, initial, max time.Duration) *backoff {
+	return &backoff{jitter: jitter, initial: initial, max: max, current: initial}
+}
+
+// next returns wait before the next retry.
+func (b *backoff) next() time.Duration {
+	switch b.jitter {
+	case FullJitter:
+		wait := between(0, b.current)
+		b.grow()
+
+		return wait
+	case DecorrelatedJitter:
+		// Next wait derives from the previous one, not from the doubled backoff.
+		b.current = between(b.initial, 3*b.current) //nolint:gomnd
+		if b.current > b.max {
+			b.current = b.max
+		}
+
+		return b.current
+	default:
+		wait := b.current
+		b.grow()
+
+		return wait
+	}
+}
+
+// grow doubles the backoff up to the maximum.
+func (b *backoff) grow() {
+	if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+}
+
+// between returns random duration between min and max, both inclusive.
+func between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	return min + time.Duration(rand.Int63n(int64(max-min)+1)) //nolint:gosec
+}
@@ -0,0 +1,129 @@
+package service_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// advance keeps moving the clock forward until done is closed. Waits drawn as zero fire without a timer, so the
+// pending ones can't be awaited by BlockUntil.
+func advance(clock *servicetest.Clock, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Minute)
+		}
+	}
+}
+
+func TestPublishRetryJitter(t *testing.T) {
+	t.Parallel()
+
+	const backoff = 100 * time.Millisecond
+
+	for jitter, inBounds := range map[service.Jitter]func(attempt int, previous, wait time.Duration) bool{
+		service.NoJitter: func(attempt int, _, wait time.Duration) bool {
+			return wait == backoff<<attempt
+		},
+		service.FullJitter: func(attempt int, _, wait time.Duration) bool {
+			return wait >= 0 && wait <= backoff<<attempt
+		},
+		service.DecorrelatedJitter: func(_ int, previous, wait time.Duration) bool {
+			return wait >= backoff && wait <= 3*previous
+		},
+	} {
+		jitter, inBounds := jitter, inBounds
+
+		t.Run(jitter.String(), func(t *testing.T) {
+			t.Parallel()
+
+			br := &flakyBroker{Broker: servicetest.NewBroker()} //nolint:exhaustruct
+			br.failures.Store(3)
+
+			clock := servicetest.NewClock(time.Now())
+			srv := service.NewService[int, int](br, echoJob{}, service.WithPublishRetries(3, backoff),
+				service.WithJitter(jitter), service.WithClock(clock))
+
+			done := make(chan struct{})
+			defer close(done)
+
+			go advance(clock, done)
+
+			if err := srv.Emit(context.Background(), "audit", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			waits := clock.Waits()
+			if len(waits) != 3 {
+				t.Fatalf("want 3 waits, got %v", waits)
+			}
+
+			previous := backoff
+
+			for attempt, wait := range waits {
+				if !inBounds(attempt, previous, wait) {
+					t.Errorf("retry %d: wait %s out of bounds, waits %v", attempt+1, wait, waits)
+				}
+
+				previous = wait
+			}
+		})
+	}
+}
+
+func TestReadinessCheckRetryJitter(t *testing.T) {
+	t.Parallel()
+
+	var failures atomic.Int64
+
+	failures.Store(2)
+
+	check := func(context.Context) error {
+		if failures.Add(-1) >= 0 {
+			return errPublish
+		}
+
+		return nil
+	}
+
+	clock := servicetest.NewClock(time.Now())
+	srv := service.NewService[int, int](servicetest.NewBroker(), echoJob{}, service.WithReadinessCheck(check, 3),
+		service.WithJitter(service.FullJitter), service.WithClock(clock))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go advance(clock, done)
+
+	go func() {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if len(clock.Waits()) >= 2 {
+				break
+			}
+		}
+
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	waits := clock.Waits()
+	if len(waits) != 2 {
+		t.Fatalf("want 2 waits, got %v", waits)
+	}
+
+	for attempt, wait := range waits {
+		if limit := time.Second << attempt; wait < 0 || wait > limit {
+			t.Errorf("retry %d: want wait up to %s, got %s", attempt+1, limit, wait)
+		}
+	}
+}
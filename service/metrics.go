@@ -0,0 +1,55 @@
+package service
+
+import "time"
+
+// Metrics receives service measurements. Published has the same signature as in broker.Metrics, so a single
+// type can receive both service and broker measurements.
+type Metrics interface {
+	// Consumed counts message received from the broker.
+	Consumed()
+	// Processed observes time spent in each stage of successfully processed message.
+	Processed(workerID uint16, stats MessageStats)
+	// Failed counts message which failed to be processed.
+	Failed(workerID uint16)
+	// Published observes time spent publishing a message, retries included, and its outcome.
+	Published(d time.Duration, err error)
+	// Workers observes number of running workers once they are started and whenever autoscaling changes it.
+	Workers(workers uint16)
+}
+
+// WithMetrics reports counts of consumed, processed, failed and published messages and durations of processing
+// stages to metrics. Nil metrics are ignored.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) {
+		if metrics != nil {
+			o.metrics = metrics
+		}
+	}
+}
+
+// noMetrics discards all measurements.
+type noMetrics struct{}
+
+func (noMetrics) Consumed()                      {}
+func (noMetrics) Processed(uint16, MessageStats) {}
+func (noMetrics) Failed(uint16)                  {}
+func (noMetrics) Published(time.Duration, error) {}
+func (noMetrics) Workers(uint16)                 {}
+
+// Ready reports whether the service is subscribed to the broker and consuming messages. It turns false once
+// shutdown started.
+func (s *Service[IN, OUT]) Ready() bool {
+	return s.ready.Load()
+}
+
+// markReady marks the service ready unless shutdown already started. Shutdown marks it not ready only after done
+// is closed, so it's never left ready.
+func (s *Service[IN, OUT]) markReady() {
+	s.ready.Store(true)
+
+	select {
+	case <-s.done:
+		s.ready.Store(false)
+	default:
+	}
+}
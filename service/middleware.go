@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Handler decodes message, executes the job and returns encoded output, nil if there is nothing to publish.
+// Routed output, see Router, is kept by the service, so nil is returned for it. Returned error marks message
+// as failed.
+type Handler func(ctx context.Context, msg broker.Message) ([]byte, error)
+
+// Middleware wraps handler, for example to recover from panics, validate messages or trace execution. Context
+// passed to next handler is passed to jobs implementing ContextJob.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware wraps decode, execute and encode of every message into middleware. The first middleware is the
+// outermost one. Publishing and acknowledging the message is done afterwards by the service.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// chain wraps handler into middleware, the first one outermost.
+func chain(middleware []Middleware, handler Handler) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// handled wraps error already logged by the service.
+type handled struct {
+	error
+}
+
+func (h handled) Unwrap() error {
+	return h.error
+}
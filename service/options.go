@@ -0,0 +1,474 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+const (
+	defaultTimeoutAttempts = 5
+	timeoutsSize           = 10000
+	routedSize             = 10000
+)
+
+// Option sets optional service parameter.
+type Option func(o *options)
+
+type options struct {
+	concurrency        uint16
+	correlationHeaders map[string]string
+	bufferSize         int
+	highWatermark      int
+	lowWatermark       int
+	ackOnConfirm       bool
+	onComplete         func(stats MessageStats)
+	noKeyPropagation   bool
+	priority           func(msg broker.Message) int
+	aging              time.Duration
+	eventsBuffer       int
+	readinessCheck     func(ctx context.Context) error
+	readinessAttempts  uint
+	dedupWindow        time.Duration
+	dedupSize          int
+	onBrokerError      func(err error)
+	extendInterval     time.Duration
+	shadow             any
+	logFields          any
+	ackOnReceive       bool
+	guarantee          DeliveryGuarantee
+	assignment         Assignment
+	idempotencyKey     func(id string, index int) string
+	shutdownTimeout    time.Duration
+	envelope           EnvelopeDecoder
+	contentTypes       map[string]PayloadDecoder
+	contentType        string
+	encoder            PayloadEncoder
+	emptyPolicy        EmptyPolicy
+	limitKey           func(msg broker.Message) string
+	keyLimit           int
+	payloadLogLimit    int
+	warmupAttempts     uint
+	output             broker.Broker
+	shutdownHooks      map[ShutdownPhase][]func(ctx context.Context)
+	nackOnFailure      bool
+	publishRetries     uint
+	retryBackoff       time.Duration
+	jitter             Jitter
+	executeTimeout     time.Duration
+	timeoutAttempts    uint
+	resumableRoutes    bool
+	manualAck          bool
+	errorPolicy        ErrorPolicy
+	clock              Clock
+	middleware         []Middleware
+	metrics            Metrics
+	autoscaling        *autoscaling
+}
+
+func newOptions(opts ...Option) *options {
+	options := defaultOptions()
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// Delivery guarantee preset is applied first, so individual options override it regardless of their order.
+	if guarantee := options.guarantee; guarantee != 0 {
+		options = defaultOptions()
+		guarantee.apply(options)
+
+		for _, opt := range opts {
+			opt(options)
+		}
+	}
+
+	if options.autoscaling != nil {
+		options.concurrency = options.autoscaling.min
+	}
+
+	if options.bufferSize < 0 {
+		options.bufferSize = int(options.concurrency)
+
+		if options.autoscaling != nil {
+			options.bufferSize = int(options.autoscaling.max)
+		}
+	}
+
+	if options.highWatermark <= 0 || options.highWatermark > options.bufferSize {
+		options.highWatermark = options.bufferSize
+	}
+
+	if options.lowWatermark <= 0 || options.lowWatermark >= options.highWatermark {
+		options.lowWatermark = options.highWatermark / 2 //nolint:gomnd
+	}
+
+	return options
+}
+
+func defaultOptions() *options {
+	concurrency := runtime.NumCPU()
+	if concurrency > math.MaxUint16 {
+		concurrency = math.MaxUint16
+	}
+
+	return &options{ //nolint:exhaustruct
+		concurrency:     uint16(concurrency),
+		bufferSize:      -1,
+		metrics:         noMetrics{},
+		clock:           wallClock{},
+		encoder:         EncodeJSON,
+		timeoutAttempts: defaultTimeoutAttempts,
+		correlationHeaders: map[string]string{
+			"trace_id":       "trace_id",
+			"correlation_id": "correlation_id",
+		},
+	}
+}
+
+// validate checks combinations of options which can't work together.
+func (o *options) validate() error {
+	if err := o.validateConcurrency(); err != nil {
+		return err
+	}
+
+	if o.ackOnReceive && o.ackOnConfirm {
+		return fmt.Errorf("%w: ack on receive excludes ack on confirm", ErrInvalidConfig)
+	}
+
+	if o.guarantee == EffectivelyOnce && (o.ackOnReceive || !o.ackOnConfirm || o.dedupWindow <= 0 ||
+		o.dedupSize <= 0) {
+		return fmt.Errorf("%w: %s requires ack on confirm and dedup", ErrInvalidConfig, o.guarantee)
+	}
+
+	if o.assignment != Shared && (o.bufferSize == 0 || o.priority != nil) {
+		return fmt.Errorf("%w: %s assignment requires plain buffer", ErrInvalidConfig, o.assignment)
+	}
+
+	if o.guarantee == AtLeastOnce && o.ackOnReceive {
+		return fmt.Errorf("%w: %s excludes ack on receive", ErrInvalidConfig, o.guarantee)
+	}
+
+	if o.manualAck && (o.ackOnReceive || o.ackOnConfirm) {
+		return fmt.Errorf("%w: manual ack excludes ack on receive and ack on confirm", ErrInvalidConfig)
+	}
+
+	if o.envelope != nil && o.contentTypes != nil {
+		return fmt.Errorf("%w: envelope excludes content types", ErrInvalidConfig)
+	}
+
+	if o.encoder == nil {
+		return fmt.Errorf("%w: output encoder is required", ErrInvalidConfig)
+	}
+
+	if err := o.autoscaling.validate(o); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateConcurrency checks there is at least one worker.
+func (o *options) validateConcurrency() error {
+	if o.concurrency == 0 {
+		return fmt.Errorf("%w: concurrency has to be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// WithConcurrency sets number of workers, which has to be positive. Default is number of CPUs.
+func WithConcurrency(concurrency uint16) Option {
+	return func(o *options) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithCorrelationHeaders maps message header keys to log field names. Values of these headers are appended
+// to every log line emitted while processing the message. Defaults to trace_id and correlation_id.
+func WithCorrelationHeaders(headers map[string]string) Option {
+	return func(o *options) {
+		o.correlationHeaders = headers
+	}
+}
+
+// WithBufferSize sets capacity of the internal buffer holding messages received from the broker until a worker
+// picks them up. Default is concurrency. Zero disables the buffer, workers then receive directly from the broker
+// only once they are free, while watermarks and priority are ignored. Prefetching done by the broker client itself
+// is configured on the broker.
+func WithBufferSize(size int) Option {
+	return func(o *options) {
+		o.bufferSize = size
+	}
+}
+
+// WithWatermarks sets number of buffered messages at which brokers implementing broker.Pauser are paused (high)
+// and resumed again (low). Defaults are full and half full buffer.
+func WithWatermarks(high, low int) Option {
+	return func(o *options) {
+		o.highWatermark = high
+		o.lowWatermark = low
+	}
+}
+
+// WithAckOnConfirm acknowledges input message only after the broker confirmed the output was persisted. Without
+// this option input message is acknowledged even if publishing fails. Unacknowledged messages are redelivered by
+// the broker.
+func WithAckOnConfirm() Option {
+	return func(o *options) {
+		o.ackOnConfirm = true
+	}
+}
+
+// WithAckOnReceive acknowledges input message as soon as it's received, before the job is executed. Messages are
+// then never redelivered, even if processing fails or the service crashes. Excludes WithAckOnConfirm.
+func WithAckOnReceive() Option {
+	return func(o *options) {
+		o.ackOnReceive = true
+	}
+}
+
+// WithDeliveryGuarantee configures acknowledging, dedup and publish confirms as a coherent preset for the given
+// guarantee. Other options override individual settings of the preset, Run fails if the result contradicts it.
+func WithDeliveryGuarantee(guarantee DeliveryGuarantee) Option {
+	return func(o *options) {
+		o.guarantee = guarantee
+	}
+}
+
+// WithOnComplete registers a callback receiving durations of decode, execute and encode stages for every
+// successfully processed message. Stages are not measured at all if there is no callback.
+func WithOnComplete(onComplete func(stats MessageStats)) Option {
+	return func(o *options) {
+		o.onComplete = onComplete
+	}
+}
+
+// WithoutKeyPropagation publishes job output without key. By default output is published with the key of the
+// input message, so results stay co-partitioned with inputs and keep per-key ordering downstream. Messages sent
+// through Emit never carry the key.
+func WithoutKeyPropagation() Option {
+	return func(o *options) {
+		o.noKeyPropagation = true
+	}
+}
+
+// WithPriority turns internal buffer into a priority queue. Workers take buffered message with the highest priority
+// returned by the priority function, for example derived from a header, and messages of equal priority in FIFO
+// order. Under sustained load low priority messages may starve. To prevent that, set aging to an interval after
+// which waiting messages gain one priority level, or zero to disable aging.
+func WithPriority(priority func(msg broker.Message) int, aging time.Duration) Option {
+	return func(o *options) {
+		o.priority = priority
+		o.aging = aging
+	}
+}
+
+// WithEvents enables streaming of lifecycle events through Service.Events using channel of the given capacity.
+// Events are never blocking the service, if the channel is full, new events are dropped until there is room again.
+func WithEvents(buffer int) Option {
+	return func(o *options) {
+		o.eventsBuffer = buffer
+	}
+}
+
+// WithReadinessCheck sets a check of downstream dependencies run after subscribing to the broker but before workers
+// start taking messages. Failed check is retried with exponential backoff up to the given number of attempts in
+// total, after which Run returns the error.
+func WithReadinessCheck(check func(ctx context.Context) error, attempts uint) Option {
+	return func(o *options) {
+		o.readinessCheck = check
+		o.readinessAttempts = attempts
+	}
+}
+
+// WithDedup skips processing of messages with exactly the same content as a message successfully processed within
+// the window, acknowledging them right away. At most size most recent hashes are kept. It catches duplicates even if
+// producers don't assign stable IDs, but costs hashing of every message, so it's disabled by default.
+func WithDedup(window time.Duration, size int) Option {
+	return func(o *options) {
+		o.dedupWindow = window
+		o.dedupSize = size
+	}
+}
+
+// WithOnBrokerError registers a callback for errors of the broker itself, like transport errors delivered instead
+// of messages or subscription closed unexpectedly, so connectivity issues can be alerted on separately from job
+// failures. Broker errors are counted in Stats regardless.
+func WithOnBrokerError(onBrokerError func(err error)) Option {
+	return func(o *options) {
+		o.onBrokerError = onBrokerError
+	}
+}
+
+// WithLogFields adds identifying fields of decoded message returned by fields, like an ID, to error log lines. It
+// should never return sensitive data, the payload itself is not logged. Message type has to match input of the
+// service job, NewService panics otherwise.
+func WithLogFields[IN any](fields func(msg *IN) map[string]string) Option {
+	return func(o *options) {
+		o.logFields = fields
+	}
+}
+
+// WithAutoExtend keeps the message lock alive while the job is executing by reporting the message in progress
+// to the broker every interval. Set interval below broker's ack wait or visibility timeout, so slow jobs are not
+// redelivered while still running. Brokers extending the deadline on their own ignore it. Jobs knowing how long
+// they need can extend the deadline themselves by ExtendAck instead.
+func WithAutoExtend(interval time.Duration) Option {
+	return func(o *options) {
+		o.extendInterval = interval
+	}
+}
+
+// WithAssignment sets strategy assigning messages to workers. Default is Shared. Other strategies split the buffer
+// evenly between workers and can't be combined with unbuffered mode or priority.
+func WithAssignment(assignment Assignment) Option {
+	return func(o *options) {
+		o.assignment = assignment
+	}
+}
+
+// WithIdempotencyKeys publishes job output with idempotency key derived from ID of the input message and index of
+// the output, so brokers supporting publish deduplication drop duplicates caused by redelivered inputs. Nil derive
+// uses IdempotencyKey. Outputs of messages without ID are published without key.
+func WithIdempotencyKeys(derive func(id string, index int) string) Option {
+	return func(o *options) {
+		if derive == nil {
+			derive = IdempotencyKey
+		}
+
+		o.idempotencyKey = derive
+	}
+}
+
+// WithShutdownTimeout bounds how long Drain waits for in-flight messages before canceling context of jobs
+// implementing ContextJob. Broker is shut down once the jobs returned and Run then returns ErrUncleanShutdown.
+// Default is to wait until all messages are processed.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// WithEnvelope decodes envelope of each message first and then its payload using decoder chosen by the envelope,
+// instead of decoding whole message as JSON. Output is encoded as usual.
+func WithEnvelope(decoder EnvelopeDecoder) Option {
+	return func(o *options) {
+		o.envelope = decoder
+	}
+}
+
+// WithContentTypes decodes each message by decoder registered for content type in its ContentTypeHeader, like
+// DecodeProtobuf for "application/protobuf", so producers can use different formats. Messages without the header
+// are decoded as JSON, messages with unregistered content type fail with ErrUnsupportedContentType. It can't be
+// combined with WithEnvelope. Output is encoded by WithOutputContentType.
+func WithContentTypes(decoders map[string]PayloadDecoder) Option {
+	return func(o *options) {
+		o.contentTypes = decoders
+	}
+}
+
+// WithOutputContentType encodes job output, routes and published messages by encoder, like EncodeProtobuf for
+// ContentTypeProtobuf, instead of JSON, and declares contentType in their ContentTypeHeader. Raw []byte output is
+// published as is regardless of the encoder, but with the same header.
+func WithOutputContentType(contentType string, encoder PayloadEncoder) Option {
+	return func(o *options) {
+		o.contentType = contentType
+		o.encoder = encoder
+	}
+}
+
+// WithResumableRoutes remembers which routes of output implementing Router were published, by ID of the input
+// message, and skips them once the message is redelivered after one of the following routes failed to publish.
+// Job has to return the same routes for the redelivered message, as routes are matched by their index. Progress
+// is kept in memory of this service instance for the latest 10000 messages only, so destinations still have to
+// tolerate duplicates, for example by deduplicating the idempotency key of each route. Messages without ID are
+// always published as a whole.
+func WithResumableRoutes() Option {
+	return func(o *options) {
+		o.resumableRoutes = true
+	}
+}
+
+// WithEmptyPolicy sets how zero-length messages, like heartbeats or tombstones, are handled. Default is SkipEmpty.
+func WithEmptyPolicy(policy EmptyPolicy) Option {
+	return func(o *options) {
+		o.emptyPolicy = policy
+	}
+}
+
+// WithKeyLimit allows at most limit messages with the same key, for example a tenant taken from a header, to be
+// executed at once across all workers. Workers wait for a free slot, so the limit should be well below
+// concurrency. Messages waiting when shutdown starts are negatively acknowledged. Empty key is not limited.
+func WithKeyLimit(key func(msg broker.Message) string, limit int) Option {
+	return func(o *options) {
+		o.limitKey = key
+		o.keyLimit = limit
+	}
+}
+
+// WithPayloadLogging logs raw data of messages failing to decode, truncated to limit bytes and quoted so binary
+// data stays readable. Payloads may contain sensitive data, so it's meant for troubleshooting outside production
+// and disabled by default.
+func WithPayloadLogging(limit int) Option {
+	return func(o *options) {
+		o.payloadLogLimit = limit
+	}
+}
+
+// WithWarmupAttempts sets number of attempts in total to warm up jobs implementing Warmer, retried with exponential
+// backoff, after which Run returns the error. Default is a single attempt.
+func WithWarmupAttempts(attempts uint) Option {
+	return func(o *options) {
+		o.warmupAttempts = attempts
+	}
+}
+
+// WithOutputBroker publishes job output, and messages sent through Emit and Publish, using a different broker than
+// the one messages are consumed from, for example to bridge Kafka to NATS. Service shuts down both brokers.
+func WithOutputBroker(output broker.Broker) Option {
+	return func(o *options) {
+		o.output = output
+	}
+}
+
+// WithNackOnFailure negatively acknowledges messages failing to decode, encode or publish, so the broker redelivers
+// them, unless the broker dead-letters them. Without this option such messages are left for the broker to redeliver
+// after ack wait, except publish failures, which are acknowledged unless WithAckOnConfirm is set.
+func WithNackOnFailure() Option {
+	return func(o *options) {
+		o.nackOnFailure = true
+	}
+}
+
+// WithPublishRetries retries failed publish of job output and messages sent by Emit, Publish and PublishTo up to
+// retries times, waiting backoff before first retry and doubling it for each following one, randomized as set by
+// WithJitter. Messages failing all attempts are handled as failed.
+func WithPublishRetries(retries uint, backoff time.Duration) Option {
+	return func(o *options) {
+		o.publishRetries = retries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithExecuteTimeout sets deadline of context passed to jobs implementing ContextJob. Other jobs can't be
+// interrupted, so they are not limited.
+func WithExecuteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.executeTimeout = timeout
+	}
+}
+
+// WithTimeoutAttempts fails message, by dead-lettering it or as set by WithNackOnFailure, once its execution exceeded
+// execute timeout the given number of times, instead of retrying it again. Attempts are counted by message ID for
+// the latest 10000 timed out messages, messages without ID are always retried. Zero retries timed out messages
+// without limit. Default is 5.
+func WithTimeoutAttempts(attempts uint) Option {
+	return func(o *options) {
+		o.timeoutAttempts = attempts
+	}
+}
@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+func TestZeroConcurrencyIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := service.NewService[int, int](servicetest.NewBroker(), echoJob{}, service.WithConcurrency(0))
+
+	if err := srv.Run(); !errors.Is(err, service.ErrInvalidConfig) {
+		t.Errorf("run: want %v, got %v", service.ErrInvalidConfig, err)
+	}
+
+	_, errs := service.ProcessAll[int, int](context.Background(), echoJob{}, [][]byte{[]byte("1")},
+		service.WithConcurrency(0))
+
+	if !errors.Is(errs[0], service.ErrInvalidConfig) {
+		t.Errorf("process all: want %v, got %v", service.ErrInvalidConfig, errs[0])
+	}
+}
+
+func TestLogFieldsAreLogged(t *testing.T) {
+	t.Parallel()
+
+	br := &flakyBroker{Broker: servicetest.NewBroker([]byte("7"))} //nolint:exhaustruct
+	br.failures.Store(1)
+
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1), service.WithNackOnFailure(),
+		service.WithLogFields(func(msg *int) map[string]string { return map[string]string{"id": strconv.Itoa(*msg)} }))
+
+	var (
+		mu   sync.Mutex
+		logs []string
+	)
+
+	srv.Debug = func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		logs = append(logs, s)
+	}
+
+	go func() {
+		settled(br.Broker, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, line := range logs {
+		if strings.Contains(line, errPublish.Error()) && strings.Contains(line, " id=7") {
+			return
+		}
+	}
+
+	t.Errorf("want publish error logged with id=7, got %q", logs)
+}
+
+func TestLogFieldsOfOtherTypePanic(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic")
+		}
+	}()
+
+	service.NewService[int, int](servicetest.NewBroker(), echoJob{},
+		service.WithLogFields(func(*string) map[string]string { return nil }))
+}
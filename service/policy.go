@@ -0,0 +1,79 @@
+package service
+
+import (
+	"fmt"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Disposition tells what to do with a message which failed to be processed.
+type Disposition uint8
+
+// Dispositions of failed messages. Zero one keeps the default handling.
+const (
+	// Ack acknowledges the message, so it's dropped, for errors which retrying can't fix.
+	Ack Disposition = iota + 1
+	// Nack negatively acknowledges the message to be redelivered right away.
+	Nack
+	// Retry leaves the message unacknowledged, so the broker redelivers it once its ack wait expired, giving
+	// transient failures time to recover.
+	Retry
+	// DeadLetter handles the message as failed permanently, so it's dead-lettered if the broker supports it,
+	// otherwise negatively acknowledged if enabled by WithNackOnFailure.
+	DeadLetter
+)
+
+// String implements fmt.Stringer interface.
+func (d Disposition) String() string {
+	switch d {
+	case Ack:
+		return "ack"
+	case Nack:
+		return "nack"
+	case Retry:
+		return "retry"
+	case DeadLetter:
+		return "dead letter"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrorPolicy chooses disposition of a message by the error it failed with, like a decode, execute or middleware
+// error, so domain errors can be mapped to broker behavior.
+type ErrorPolicy func(err error) Disposition
+
+// WithErrorPolicy consults policy for each failed message before the default handling, which dead-letters failed
+// messages and retries timed out ones. Messages for which policy returns zero Disposition are handled by default.
+// Messages canceled by shutdown are always negatively acknowledged.
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(o *options) {
+		o.errorPolicy = policy
+	}
+}
+
+// dispose settles failed message as chosen by the error policy. It reports false if policy left it to the
+// default handling.
+func (s *Service[IN, OUT]) dispose(workerID uint16, msg broker.Message, err error, logCtx string) bool {
+	if s.opts.errorPolicy == nil {
+		return false
+	}
+
+	disposition := s.opts.errorPolicy(err)
+
+	switch disposition {
+	case Ack:
+		msg.Ack()
+	case Nack:
+		msg.Nack()
+	case Retry:
+	case DeadLetter:
+		s.fail(workerID, msg, err, logCtx)
+	default:
+		return false
+	}
+
+	s.Debug(fmt.Sprintf("worker %d error policy: %s%s", workerID, disposition, logCtx))
+
+	return true
+}
@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+func TestErrorPolicyChoosesDisposition(t *testing.T) {
+	t.Parallel()
+
+	for name, test := range map[string]struct {
+		disposition                 service.Disposition
+		acked, nacked, deadLettered int
+	}{
+		"ack":         {disposition: service.Ack, acked: 1},
+		"nack":        {disposition: service.Nack, nacked: 1},
+		"retry":       {disposition: service.Retry},
+		"dead letter": {disposition: service.DeadLetter, acked: 1, deadLettered: 1},
+		"default":     {disposition: 0, acked: 1, deadLettered: 1},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			consulted := make(chan error, 1)
+			br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("invalid"))} //nolint:exhaustruct
+			srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1),
+				service.WithErrorPolicy(func(err error) service.Disposition {
+					consulted <- err
+
+					return test.disposition
+				}))
+
+			go func() {
+				select {
+				case err := <-consulted:
+					if err == nil {
+						t.Error("want policy consulted with the error")
+					}
+				case <-time.After(time.Second):
+					t.Error("want policy consulted")
+				}
+
+				srv.Drain()
+			}()
+
+			if err := srv.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			acked, nacked, deadLettered := br.Acked(), br.Nacked(), br.deadLettered.Load()
+			if len(acked) != test.acked || len(nacked) != test.nacked || int(deadLettered) != test.deadLettered {
+				t.Errorf("want %d acked, %d nacked and %d dead-lettered, got %v, %v and %d", test.acked,
+					test.nacked, test.deadLettered, acked, nacked, deadLettered)
+			}
+		})
+	}
+}
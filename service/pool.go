@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors.
+var (
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// Pool is a bounded worker pool for in-process work submitted directly from Go code, without a broker. Service is
+// built on it as well, with workers taking messages from the broker instead of submitted tasks.
+// Exported field Debug can be used for debugging.
+type Pool[T any] struct {
+	fn     func(ctx context.Context, task T) error
+	tasks  chan T
+	ctx    context.Context //nolint:containedctx
+	cancel context.CancelFunc
+	// Closed once shutdown started, so Submit waiting for free buffer doesn't block it.
+	quit   chan struct{}
+	once   sync.Once
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	stats  counters
+	Debug  func(s string)
+	// OnError optionally receives tasks for which fn returned an error.
+	OnError func(task T, err error)
+}
+
+// NewPool creates new pool and starts its workers. Concurrency and buffer size are set by the same options as for
+// the service, other options are ignored. Buffer size zero makes Submit wait until a worker is free. It panics if
+// concurrency is zero.
+func NewPool[T any](fn func(ctx context.Context, task T) error, opts ...Option) *Pool[T] {
+	options := newOptions(opts...)
+
+	if err := options.validateConcurrency(); err != nil {
+		panic("service: " + err.Error())
+	}
+
+	pool := newPool(fn)
+	pool.tasks = make(chan T, options.bufferSize)
+
+	for i := 0; i < int(options.concurrency); i++ {
+		pool.spawn(pool.run)
+	}
+
+	return pool
+}
+
+// newPool creates pool without workers and tasks, workers are started by spawn.
+func newPool[T any](fn func(ctx context.Context, task T) error) *Pool[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pool[T]{ //nolint:exhaustruct
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+		quit:   make(chan struct{}),
+		Debug:  func(string) {},
+	}
+}
+
+// Submit queues task, waiting while the buffer is full. It returns ErrPoolClosed once Shutdown was called, also
+// if it was waiting.
+func (p *Pool[T]) Submit(task T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.quit:
+		return ErrPoolClosed
+	}
+}
+
+// Shutdown stops accepting new tasks and waits until all submitted tasks are executed. If ctx is done first,
+// context passed to running tasks is canceled, remaining queued tasks are dropped and ctx error is returned once
+// running tasks returned.
+func (p *Pool[T]) Shutdown(ctx context.Context) error {
+	p.close()
+
+	if p.await(ctx) {
+		return fmt.Errorf("shutdown: %w", ctx.Err())
+	}
+
+	p.cancel()
+
+	return nil
+}
+
+// Stats returns current pool counters.
+func (p *Pool[T]) Stats() Stats {
+	return p.stats.stats()
+}
+
+// spawn starts worker unless the pool is closed. It reports whether the worker was started.
+func (p *Pool[T]) spawn(worker func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		worker()
+	}()
+
+	return true
+}
+
+// close stops accepting new tasks and workers. Workers taking tasks stop once the queued ones are executed.
+func (p *Pool[T]) close() {
+	p.once.Do(func() { close(p.quit) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+
+		if p.tasks != nil {
+			close(p.tasks)
+		}
+	}
+}
+
+// await waits for workers to finish. Once ctx is done first, it cancels context of running tasks and waits for
+// workers to finish anyway. It reports whether the tasks were canceled.
+func (p *Pool[T]) await(ctx context.Context) bool {
+	if ctx.Done() == nil {
+		p.wg.Wait()
+
+		return false
+	}
+
+	idle := make(chan struct{})
+
+	go func() {
+		p.wg.Wait()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		return false
+	case <-ctx.Done():
+		p.Debug("shutdown deadline expired, canceling running tasks")
+		p.cancel()
+
+		<-idle
+
+		return true
+	}
+}
+
+func (p *Pool[T]) run() {
+	for task := range p.tasks {
+		if p.ctx.Err() != nil {
+			continue
+		}
+
+		p.execute(task)
+	}
+}
+
+func (p *Pool[T]) execute(task T) {
+	p.stats.begin()
+	defer p.stats.end()
+
+	if err := p.fn(p.ctx, task); err != nil {
+		p.Debug(fmt.Sprintf("executing task: %v", err))
+
+		if p.OnError != nil {
+			p.OnError(task, err)
+		}
+	}
+}
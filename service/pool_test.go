@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+)
+
+func TestPoolShutdownHonorsContextWithPendingSubmit(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	pool := service.NewPool(func(ctx context.Context, _ int) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+
+		return nil
+	}, service.WithConcurrency(1), service.WithBufferSize(0))
+
+	if err := pool.Submit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	submitted := make(chan error)
+
+	// Worker is busy, so the second task waits for it.
+	go func() { submitted <- pool.Submit(2) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+
+	if err := <-submitted; !errors.Is(err, service.ErrPoolClosed) {
+		t.Errorf("want %v, got %v", service.ErrPoolClosed, err)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"container/heap"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// priorityQueue orders buffered messages by priority, highest first, keeping FIFO order for equal priorities.
+// With aging, priority of waiting messages grows by one for every aging interval since they were queued. Since
+// all messages age at the same rate, aging is applied once on push and doesn't change order of queued messages.
+type priorityQueue struct {
+	items    []prioritized
+	seq      uint64
+	start    time.Time
+	priority func(msg broker.Message) int
+	aging    time.Duration
+}
+
+type prioritized struct {
+	msg   broker.Message
+	score float64
+	seq   uint64
+}
+
+func newPriorityQueue(priority func(msg broker.Message) int, aging time.Duration) *priorityQueue {
+	return &priorityQueue{ //nolint:exhaustruct
+		start:    time.Now(),
+		priority: priority,
+		aging:    aging,
+	}
+}
+
+// push adds message to the queue.
+func (q *priorityQueue) push(msg broker.Message) {
+	score := float64(q.priority(msg))
+
+	if q.aging > 0 {
+		score -= float64(time.Since(q.start)) / float64(q.aging)
+	}
+
+	q.seq++
+
+	heap.Push(q, prioritized{msg: msg, score: score, seq: q.seq})
+}
+
+// peek returns message with the highest priority without removing it.
+func (q *priorityQueue) peek() broker.Message {
+	return q.items[0].msg
+}
+
+// pop removes message with the highest priority.
+func (q *priorityQueue) pop() broker.Message {
+	item, _ := heap.Pop(q).(prioritized)
+
+	return item.msg
+}
+
+// Len implements heap.Interface.
+func (q *priorityQueue) Len() int {
+	return len(q.items)
+}
+
+// Less implements heap.Interface.
+func (q *priorityQueue) Less(i, j int) bool {
+	if q.items[i].score != q.items[j].score {
+		return q.items[i].score > q.items[j].score
+	}
+
+	return q.items[i].seq < q.items[j].seq
+}
+
+// Swap implements heap.Interface.
+func (q *priorityQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+// Push implements heap.Interface.
+func (q *priorityQueue) Push(x any) {
+	item, _ := x.(prioritized)
+	q.items = append(q.items, item)
+}
+
+// Pop implements heap.Interface.
+func (q *priorityQueue) Pop() any {
+	last := len(q.items) - 1
+	item := q.items[last]
+	q.items[last] = prioritized{} //nolint:exhaustruct
+	q.items = q.items[:last]
+
+	return item
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+// recent remembers values of the latest message IDs, bounded by size, evicting the least recently updated one.
+type recent[V any] struct {
+	size  int
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type recentEntry[V any] struct {
+	id    string
+	value V
+}
+
+func newRecent[V any](size int) *recent[V] {
+	return &recent[V]{ //nolint:exhaustruct
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// get returns value remembered for the id.
+func (r *recent[V]) get(id string) (V, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.items[id]
+	if !ok {
+		var zero V
+
+		return zero, false
+	}
+
+	entry, _ := elem.Value.(*recentEntry[V])
+
+	return entry.value, true
+}
+
+// update replaces value remembered for the id by result of fn, called with zero value for unknown id, and
+// returns the new value.
+func (r *recent[V]) update(id string, fn func(value V) V) V {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.items[id]; ok {
+		entry, _ := elem.Value.(*recentEntry[V])
+		entry.value = fn(entry.value)
+		r.order.MoveToBack(elem)
+
+		return entry.value
+	}
+
+	if r.order.Len() >= r.size {
+		r.remove(r.order.Front())
+	}
+
+	var zero V
+
+	entry := &recentEntry[V]{id: id, value: fn(zero)}
+	r.items[id] = r.order.PushBack(entry)
+
+	return entry.value
+}
+
+// forget removes the id.
+func (r *recent[V]) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.items[id]; ok {
+		r.remove(elem)
+	}
+}
+
+func (r *recent[V]) remove(elem *list.Element) {
+	entry, _ := r.order.Remove(elem).(*recentEntry[V])
+	delete(r.items, entry.id)
+}
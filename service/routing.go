@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Route is a single output published to its own destination.
+type Route struct {
+	// Subject or topic to publish to. Empty one means the same destination as for output which isn't routed.
+	Subject string
+	// Payload encoded the same way as job output.
+	Payload any
+}
+
+// Router is implemented by job output which is published to multiple destinations instead of itself, for example
+// successful results, validation errors and audit events to different subjects. Routes are published in order,
+// input message is acknowledged only once all of them were published. If one fails, message fails as a whole, so
+// routes published before are published again once it's delivered again, unless WithResumableRoutes is set.
+type Router interface {
+	Routes() []Route
+}
+
+// Routes is job output implementing Router, for jobs with no output type of their own.
+type Routes []Route
+
+// Routes implements Router interface.
+func (r Routes) Routes() []Route {
+	return r
+}
+
+// encodedRoute is route with encoded payload.
+type encodedRoute struct {
+	subject string
+	buf     *bytes.Buffer
+}
+
+type encodedRoutes []encodedRoute
+
+// encodeRoutes encodes payloads of routes into pooled buffers.
+func encodeRoutes(encoder PayloadEncoder, routes []Route) (encodedRoutes, error) {
+	encoded := make(encodedRoutes, 0, len(routes))
+
+	for index, route := range routes {
+		buf, err := encode(encoder, route.Payload)
+		if err != nil {
+			encoded.release()
+
+			return nil, fmt.Errorf("route %d: %w", index, err)
+		}
+
+		encoded = append(encoded, encodedRoute{subject: route.Subject, buf: buf})
+	}
+
+	return encoded, nil
+}
+
+// release returns buffers to the pool.
+func (r encodedRoutes) release() {
+	for _, route := range r {
+		bufferPool.Put(route.buf)
+	}
+}
+
+// publishOutput publishes output of the message, if any, and its routes. Each of them gets own idempotency key.
+func (s *Service[IN, OUT]) publishOutput(msg broker.Message, output []byte, routes encodedRoutes) error {
+	if output != nil {
+		return s.pub(output, s.pubOptions(msg, 0))
+	}
+
+	id := msg.ID()
+	resumable := s.routed != nil && id != ""
+
+	var published map[int]struct{}
+
+	if resumable {
+		published, _ = s.routed.get(id)
+	}
+
+	for index, route := range routes {
+		if _, ok := published[index]; ok {
+			continue
+		}
+
+		opts := s.pubOptions(msg, index)
+
+		if route.subject != "" {
+			opts = append(opts, broker.WithSubject(route.subject))
+		}
+
+		if err := s.pub(route.buf.Bytes(), opts); err != nil {
+			return fmt.Errorf("route %d: %w", index, err)
+		}
+
+		if resumable {
+			s.routed.update(id, func(published map[int]struct{}) map[int]struct{} {
+				return withRoute(published, index)
+			})
+		}
+	}
+
+	if resumable {
+		s.routed.forget(id)
+	}
+
+	return nil
+}
+
+// withRoute returns copy of published route indices with the index added. Recorded indices are never modified, so
+// they can be read without holding the lock.
+func withRoute(published map[int]struct{}, index int) map[int]struct{} {
+	routes := make(map[int]struct{}, len(published)+1)
+
+	for route := range published {
+		routes[route] = struct{}{}
+	}
+
+	routes[index] = struct{}{}
+
+	return routes
+}
@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// splitJob routes input to the default destination and its double to the doubled topic.
+type splitJob struct{}
+
+func (splitJob) Execute(msg *int) *service.Routes {
+	doubled := *msg * 2
+
+	return &service.Routes{{Subject: "", Payload: msg}, {Subject: "doubled", Payload: doubled}}
+}
+
+func TestRoutesFallBackToDefaultTopic(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("21"))
+	srv := service.NewService[int, service.Routes](br, splitJob{}, service.WithConcurrency(1))
+
+	go func() {
+		settled(br, 1)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	published := br.Published()
+	if len(published) != 2 {
+		t.Fatalf("want 2 published messages, got %d", len(published))
+	}
+
+	for i, want := range []struct{ subject, data string }{{"", "21"}, {"doubled", "42"}} {
+		if got := published[i]; got.Options.Subject != want.subject || string(got.Data) != want.data {
+			t.Errorf("message %d: want %s to %q, got %s to %q", i, want.data, want.subject, got.Data,
+				got.Options.Subject)
+		}
+	}
+}
+
+// fanOutJob routes each input to subjects a, b and c.
+type fanOutJob struct{}
+
+func (fanOutJob) Execute(msg *int) *service.Routes {
+	return &service.Routes{{Subject: "a", Payload: msg}, {Subject: "b", Payload: msg}, {Subject: "c", Payload: msg}}
+}
+
+// routeBroker redelivers its only message whenever it's negatively acknowledged and fails the first publish to
+// subject b.
+type routeBroker struct {
+	messages  chan broker.Message
+	acked     chan struct{}
+	mu        sync.Mutex
+	failed    bool
+	published []string
+}
+
+func newRouteBroker() *routeBroker {
+	b := &routeBroker{messages: make(chan broker.Message, 1), acked: make(chan struct{})} //nolint:exhaustruct
+	b.deliver()
+
+	return b
+}
+
+func (b *routeBroker) deliver() {
+	b.messages <- broker.Message{ //nolint:exhaustruct
+		Data:       []byte("1"),
+		Ack:        func() { close(b.acked) },
+		Nack:       b.deliver,
+		InProgress: func() {},
+	}.WithID("fan-out")
+}
+
+func (b *routeBroker) Sub() (<-chan broker.Message, error) { return b.messages, nil }
+
+func (b *routeBroker) Pub(_ []byte, opts ...broker.PubOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subject := broker.NewPubOptions(opts...).Subject
+	if subject == "b" && !b.failed {
+		b.failed = true
+
+		return errPublish
+	}
+
+	b.published = append(b.published, subject)
+
+	return nil
+}
+
+func (b *routeBroker) Exit() {}
+
+func (b *routeBroker) subjects() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.published...)
+}
+
+func TestResumableRoutesSkipPublishedOnRedelivery(t *testing.T) {
+	t.Parallel()
+
+	for name, test := range map[string]struct {
+		opts []service.Option
+		want []string
+	}{
+		"default":   {opts: nil, want: []string{"a", "a", "b", "c"}},
+		"resumable": {opts: []service.Option{service.WithResumableRoutes()}, want: []string{"a", "b", "c"}},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			br := newRouteBroker()
+			opts := append([]service.Option{service.WithConcurrency(1), service.WithNackOnFailure()}, test.opts...)
+			srv := service.NewService[int, service.Routes](br, fanOutJob{}, opts...)
+
+			go func() {
+				select {
+				case <-br.acked:
+				case <-time.After(time.Second):
+					t.Error("want message acknowledged once all routes were published")
+				}
+
+				srv.Drain()
+			}()
+
+			if err := srv.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := br.subjects()
+			if len(got) != len(test.want) {
+				t.Fatalf("want published %v, got %v", test.want, got)
+			}
+
+			for i := range test.want {
+				if got[i] != test.want[i] {
+					t.Errorf("want published %v, got %v", test.want, got)
+
+					break
+				}
+			}
+		})
+	}
+}
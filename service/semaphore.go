@@ -0,0 +1,60 @@
+package service
+
+import "sync"
+
+// keyedSemaphore limits number of concurrent holders per key. Semaphores of keys without holders are removed, so
+// dynamic key space doesn't leak memory.
+type keyedSemaphore struct {
+	limit int
+	mu    sync.Mutex
+	keys  map[string]*semaphore
+}
+
+type semaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newKeyedSemaphore(limit int) *keyedSemaphore {
+	return &keyedSemaphore{ //nolint:exhaustruct
+		limit: limit,
+		keys:  make(map[string]*semaphore),
+	}
+}
+
+// acquire waits for a free slot of the key until done is closed. It returns release function and whether the slot
+// was acquired.
+func (k *keyedSemaphore) acquire(key string, done <-chan struct{}) (func(), bool) {
+	k.mu.Lock()
+
+	sem, ok := k.keys[key]
+	if !ok {
+		sem = &semaphore{slots: make(chan struct{}, k.limit), refs: 0}
+		k.keys[key] = sem
+	}
+
+	sem.refs++
+
+	k.mu.Unlock()
+
+	select {
+	case sem.slots <- struct{}{}:
+		return func() {
+			<-sem.slots
+			k.unref(key, sem)
+		}, true
+	case <-done:
+		k.unref(key, sem)
+
+		return nil, false
+	}
+}
+
+func (k *keyedSemaphore) unref(key string, sem *semaphore) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if sem.refs--; sem.refs == 0 {
+		delete(k.keys, key)
+	}
+}
@@ -0,0 +1,51 @@
+package service_test
+
+import (
+	"strconv"
+	"testing"
+
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+// orderJob records order in which messages are executed.
+type orderJob struct {
+	executed *[]int
+}
+
+func (j orderJob) Execute(msg *int) *int {
+	*j.executed = append(*j.executed, *msg)
+
+	return msg
+}
+
+func TestRunSequentialKeepsOrder(t *testing.T) {
+	t.Parallel()
+
+	const messages = 50
+
+	data := make([][]byte, messages)
+
+	for i := range data {
+		data[i] = []byte(strconv.Itoa(i))
+	}
+
+	var executed []int
+
+	br := servicetest.NewBroker(data...)
+
+	if err := servicetest.RunSequential[int, int](br, orderJob{executed: &executed}); err != nil {
+		t.Fatal(err)
+	}
+
+	acked := br.Acked()
+
+	if len(executed) != messages || len(acked) != messages {
+		t.Fatalf("want %d executed and acknowledged messages, got %d and %d", messages, len(executed), len(acked))
+	}
+
+	for i := range executed {
+		if executed[i] != i || acked[i] != i {
+			t.Errorf("message %d: executed %d, acknowledged %d", i, executed[i], acked[i])
+		}
+	}
+}
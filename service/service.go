@@ -2,13 +2,21 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"go.ectobit.com/oxeye/broker"
 )
@@ -16,6 +24,30 @@ import (
 // Errors.
 var (
 	ErrInvalidMessageType = errors.New("invalid message type")
+	ErrSubscriptionClosed = errors.New("subscription closed by broker")
+	ErrUncleanShutdown    = errors.New("shutdown timeout expired")
+	ErrEmptyMessage       = errors.New("empty message")
+
+	// errShutdown reports message abandoned because of shutdown.
+	errShutdown = errors.New("shutdown")
+)
+
+// Message headers used for request/reply. If incoming message carries ReplyToHeader, job output is published
+// to that subject instead of the default one and CorrelationIDHeader is copied to the reply.
+const (
+	ReplyToHeader       = "reply-to"
+	CorrelationIDHeader = "correlation-id"
+)
+
+const (
+	readinessBackoff    = time.Second
+	maxReadinessBackoff = 30 * time.Second
+	exitHooksTimeout    = 5 * time.Second
+)
+
+var (
+	// bufferPool reuses buffers for encoding outgoing messages.
+	bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }} //nolint:gochecknoglobals
 )
 
 // Job defines common job methods.
@@ -23,105 +55,1256 @@ type Job[IN, OUT any] interface {
 	Execute(msg *IN) *OUT
 }
 
+// ContextJob is optionally implemented by jobs which honor cancellation. Service calls ExecuteContext instead of
+// Execute with context canceled once execute timeout or shutdown timeout expires. Output of canceled execution is
+// dropped and the message is negatively acknowledged to be retried. Message which exceeded execute timeout as many
+// times as set by WithTimeoutAttempts, counted by its ID, fails instead, so it's dead-lettered rather than retried
+// without end on brokers with no delivery limit, like Kafka. Messages canceled by shutdown are always retried.
+type ContextJob[IN, OUT any] interface {
+	ExecuteContext(ctx context.Context, msg *IN) *OUT
+}
+
+// Warmer is optionally implemented by jobs which prime their resources, like connections or caches, before the
+// first message. Warmup is called once after readiness check and before workers start, jobs set by ReplaceJob are
+// not warmed up.
+type Warmer interface {
+	Warmup(ctx context.Context) error
+}
+
+// Emitter publishes side-channel messages independently of the job output.
+type Emitter interface {
+	Emit(ctx context.Context, topic string, v any) error
+}
+
 // Service is a multithreaded service with configurable job to be executed.
 type Service[IN, OUT any] struct {
-	concurrency uint8
-	broker      broker.Broker
-	done        chan struct{}
-	wg          sync.WaitGroup
-	job         Job[IN, OUT]
-	Debug       func(s string)
+	opts    *options
+	broker  broker.Broker
+	output  broker.Broker
+	done    chan struct{}
+	stopped sync.Once
+	closed  sync.Once
+	drained sync.Once
+	mu      sync.Mutex
+	job     atomic.Pointer[Job[IN, OUT]]
+	events  chan Event
+	dedup   *dedup
+	// Number of execute timeouts per message ID, nil unless timeout attempts are limited.
+	timeouts *recent[uint]
+	// Indices of routes published per message ID, nil unless routes are resumable.
+	routed *recent[map[int]struct{}]
+	// Messages left to be acknowledged by the job, nil unless in manual ack mode.
+	acks    *manualAcks
+	shadow  *shadow[IN, OUT]
+	limiter *keyedSemaphore
+	result  atomic.Pointer[ShutdownResult]
+	ready   atomic.Bool
+	// Closed once workers finished during draining, forwarders then settle messages left in buffers.
+	idle       chan struct{}
+	forwarders sync.WaitGroup
+	// Pool running workers, its context is parent context of jobs, canceled once shutdown timeout expired.
+	pool *Pool[delivery]
+	// Counters at the moment shutdown started, set once by stop.
+	shutdownInFlight  int64
+	shutdownCompleted int64
+	// Messages never acknowledged by the job in manual ack mode, set once by awaitIdle.
+	unacked int64
+	// Identifying fields of decoded message set by WithLogFields, nil if not set.
+	fields func(msg *IN) map[string]string
+	Debug  func(s string)
 }
 
-// NewService creates new service.
-func NewService[IN, OUT any](concurrency uint8, broker broker.Broker, job Job[IN, OUT]) *Service[IN, OUT] {
-	return &Service[IN, OUT]{ //nolint:exhaustruct
-		concurrency: concurrency,
-		broker:      broker,
-		done:        make(chan struct{}),
-		job:         job,
-		Debug:       func(string) {},
+// NewService creates new service. Optional parameters not set via options get sensible defaults.
+// It panics if broker or job is nil, use Config to get an error instead.
+func NewService[IN, OUT any](broker broker.Broker, job Job[IN, OUT], opts ...Option) *Service[IN, OUT] {
+	if broker == nil {
+		panic("service: " + ErrMissingBroker.Error())
+	}
+
+	if job == nil {
+		panic("service: " + ErrMissingJob.Error())
+	}
+
+	options := newOptions(opts...)
+
+	var events chan Event
+
+	if options.eventsBuffer > 0 {
+		events = make(chan Event, options.eventsBuffer)
+	}
+
+	srv := &Service[IN, OUT]{ //nolint:exhaustruct
+		opts:   options,
+		broker: broker,
+		output: broker,
+		done:   make(chan struct{}),
+		idle:   make(chan struct{}),
+		events: events,
+		Debug:  func(string) {},
+	}
+
+	if options.dedupWindow > 0 && options.dedupSize > 0 {
+		srv.dedup = newDedup(options.dedupWindow, options.dedupSize)
+	}
+
+	if options.executeTimeout > 0 && options.timeoutAttempts > 0 {
+		srv.timeouts = newRecent[uint](timeoutsSize)
+	}
+
+	if options.manualAck {
+		srv.acks = newManualAcks()
+	}
+
+	if options.resumableRoutes {
+		srv.routed = newRecent[map[int]struct{}](routedSize)
 	}
+
+	srv.shadow = shadowOf[IN, OUT](options)
+	srv.fields = logFieldsOf[IN](options)
+	srv.pool = newPool(srv.execute)
+	srv.pool.Debug = func(s string) { srv.Debug(s) }
+
+	if options.output != nil {
+		srv.output = options.output
+	}
+
+	if options.limitKey != nil && options.keyLimit > 0 {
+		srv.limiter = newKeyedSemaphore(options.keyLimit)
+	}
+
+	srv.job.Store(&job)
+
+	return srv
 }
 
-// Run executes service reacting on termination signals for graceful shutdown.
+// ReplaceJob atomically swaps the job executed by workers without touching the broker connection. Messages
+// already being executed finish on the old job, all messages picked up afterwards use the new one, so both jobs
+// may run concurrently for a while. It's safe to be called at any time from any goroutine.
+func (s *Service[IN, OUT]) ReplaceJob(job Job[IN, OUT]) {
+	s.job.Store(&job)
+}
+
+// Run executes service reacting on termination signals for graceful shutdown. It also returns once Drain or
+// Shutdown is called.
 func (s *Service[IN, OUT]) Run() error {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	s.Debug(fmt.Sprintf("starting worker pool with %d workers", s.concurrency))
+	return s.RunContext(ctx)
+}
+
+// RunContext executes service until ctx is canceled, which starts graceful shutdown the same way as Drain.
+// It also returns once Drain or Shutdown is called.
+func (s *Service[IN, OUT]) RunContext(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Debug("graceful shutdown")
+			s.stop()
+		case <-s.done:
+		}
+	}()
+
+	if err := s.opts.validate(); err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+
+	s.Debug(fmt.Sprintf("starting worker pool with %d workers", s.opts.concurrency))
+	s.checkPrefetch()
 
 	sub, err := s.broker.Sub()
 	if err != nil {
 		return fmt.Errorf("broker: %w", err)
 	}
 
-	for workerID := uint8(1); workerID <= s.concurrency; workerID++ {
-		go s.run(workerID, sub)
+	flow := newFlowControl(s.broker, s.opts.highWatermark, s.opts.lowWatermark, s.Debug)
+
+	var (
+		messages <-chan broker.Message
+		assigned []chan broker.Message
+	)
+
+	switch {
+	case s.opts.bufferSize == 0:
+		// Workers receive directly from the broker, so a message is taken only once a worker is free.
+		messages = sub
+		flow = nil
+	case s.opts.priority != nil:
+		// Messages wait in the priority queue and are handed to workers one by one.
+		buffer := make(chan broker.Message)
+		messages = buffer
+		prioritized := flow
+
+		s.goForward(func() { s.forwardPrioritized(sub, buffer, prioritized) })
+
+		flow = nil
+	case s.opts.assignment != Shared:
+		// Each worker has own buffer and messages are assigned to workers by the strategy.
+		assigned = newAssigned(s.opts.concurrency, s.opts.bufferSize)
+
+		s.goForward(func() { s.forwardAssigned(sub, assigned, flow) })
+	default:
+		buffer := make(chan broker.Message, s.opts.bufferSize)
+		messages = buffer
+
+		s.goForward(func() { s.forward(sub, buffer, flow) })
+	}
+
+	if err := s.awaitReadiness(); err != nil {
+		s.Drain()
+
+		return err
 	}
 
-	<-signals
-	s.Debug("graceful shutdown")
-	s.wg.Wait()
-	s.broker.Exit()
+	if err := s.warmup(); err != nil {
+		s.Drain()
+
+		return err
+	}
+
+	for index := 0; index < int(s.opts.concurrency); index++ {
+		workerID := uint16(index + 1)
+
+		if assigned != nil {
+			buffer := assigned[index]
+
+			s.pool.spawn(func() { s.run(workerID, buffer, flow, func() int { return bufferedAll(assigned) }, nil) })
+
+			continue
+		}
+
+		s.pool.spawn(func() { s.run(workerID, messages, flow, func() int { return len(messages) }, nil) })
+	}
+
+	s.opts.metrics.Workers(s.opts.concurrency)
+
+	if s.opts.autoscaling != nil {
+		go s.autoscale(messages, flow)
+	}
+	s.markReady()
+
+	<-s.done
+	s.Drain()
+
+	s.Debug(fmt.Sprintf("peak in-flight messages %d of %d workers", s.pool.stats.peakInFlight.Load(),
+		s.opts.concurrency))
+
+	return s.shutdownError()
+}
+
+// Drain stops taking new messages, waits for in-flight messages to be processed and shuts down the broker.
+// It returns once the pool is idle or shutdown timeout expired, so it can be called from a pre-stop hook before
+// letting the process exit. Run returns as well. Drain is safe to be called multiple times and concurrently.
+func (s *Service[IN, OUT]) Drain() {
+	s.drain(context.Background())
+}
+
+// Shutdown is Drain which waits for in-flight messages at most until ctx is done, or shutdown timeout expired
+// if it's sooner. Context of jobs still running is canceled then and ErrUncleanShutdown is returned once they
+// returned. Jobs not implementing ContextJob can't be canceled and are waited for. Broker is shut down afterwards
+// in any case. If shutdown already started, it waits for it and returns its outcome.
+func (s *Service[IN, OUT]) Shutdown(ctx context.Context) error {
+	s.drain(ctx)
+
+	return s.shutdownError()
+}
+
+// shutdownError returns ErrUncleanShutdown if graceful shutdown didn't complete all in-flight messages.
+func (s *Service[IN, OUT]) shutdownError() error {
+	if result, _ := s.ShutdownResult(); result.TimedOut {
+		return fmt.Errorf("%w: %d of %d in-flight messages completed", ErrUncleanShutdown, result.Completed,
+			result.InFlight)
+	}
 
 	return nil
 }
 
-func (s *Service[IN, OUT]) run(workerID uint8, messages <-chan broker.Message) {
-	s.Debug(fmt.Sprintf("starting worker %d", workerID))
-	s.wg.Add(1)
+// drain stops the service, waiting for in-flight messages at most until ctx is done.
+func (s *Service[IN, OUT]) drain(ctx context.Context) {
+	s.drained.Do(func() {
+		s.stop()
 
-	for {
+		timedOut := s.awaitIdle(ctx)
+
+		// Messages left in buffers are settled before brokers are shut down.
+		s.mu.Lock()
+		close(s.idle)
+		s.forwarders.Wait()
+
+		s.mu.Unlock()
+
+		s.enterPhase(InFlightComplete)
+
+		s.exitBrokers()
+
+		s.enterPhase(BrokerFlushed)
+
+		s.result.Store(&ShutdownResult{
+			InFlight:  s.shutdownInFlight,
+			Completed: s.pool.stats.completed.Load() - s.shutdownCompleted,
+			TimedOut:  timedOut,
+			Unacked:   s.unacked,
+		})
+
+		s.pool.cancel()
+		s.enterPhase(WorkersStopped)
+
+		if s.events != nil {
+			close(s.events)
+		}
+	})
+}
+
+// awaitIdle waits for workers to finish. Once ctx is done or the shutdown timeout, if set, expired, it cancels
+// context of running jobs and waits for workers to finish anyway. It reports whether the jobs were canceled.
+func (s *Service[IN, OUT]) awaitIdle(ctx context.Context) bool {
+	if s.opts.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.opts.shutdownTimeout)
+		defer cancel()
+	}
+
+	// Brokers and events are closed only once workers stopped using them.
+	s.pool.close()
+
+	timedOut := s.pool.await(ctx)
+
+	if s.acks == nil {
+		return timedOut
+	}
+
+	if s.unacked = s.acks.await(ctx); s.unacked > 0 {
+		s.Debug(fmt.Sprintf("%d messages were never acknowledged by the job", s.unacked))
+
+		return true
+	}
+
+	return timedOut
+}
+
+// ShutdownResult reports outcome of graceful shutdown once Drain returned, ok is false before that.
+func (s *Service[IN, OUT]) ShutdownResult() (ShutdownResult, bool) {
+	result := s.result.Load()
+	if result == nil {
+		return ShutdownResult{}, false //nolint:exhaustruct
+	}
+
+	return *result, true
+}
+
+// stop signals workers to stop taking new messages.
+func (s *Service[IN, OUT]) stop() {
+	s.stopped.Do(func() {
+		s.shutdownInFlight = s.pool.stats.inFlight.Load()
+		s.shutdownCompleted = s.pool.stats.completed.Load()
+
+		s.Debug("draining")
+		s.emit(ShutdownInitiated, 0, nil)
+		s.enterPhase(DrainStarted)
+		close(s.done)
+		s.ready.Store(false)
+		s.enterPhase(ConsumptionStopped)
+	})
+}
+
+// checkPrefetch warns if the broker doesn't deliver enough messages at once to keep all workers busy.
+func (s *Service[IN, OUT]) checkPrefetch() {
+	prefetcher, ok := s.broker.(broker.Prefetcher)
+	if !ok {
+		return
+	}
+
+	if prefetch := prefetcher.Prefetch(); prefetch > 0 && prefetch < int(s.opts.concurrency) {
+		s.Debug(fmt.Sprintf("warning: broker delivers at most %d unacknowledged messages, %d of %d workers "+
+			"will be idle", prefetch, int(s.opts.concurrency)-prefetch, s.opts.concurrency))
+	}
+}
+
+// awaitReadiness runs readiness check, if any, until it passes, retrying with exponential backoff. It gives up
+// on shutdown without an error.
+func (s *Service[IN, OUT]) awaitReadiness() error {
+	if s.opts.readinessCheck == nil {
+		return nil
+	}
+
+	return s.retry("readiness check", s.opts.readinessCheck, s.opts.readinessAttempts)
+}
+
+// warmup warms up the job if it implements Warmer, retrying with exponential backoff. It gives up on shutdown
+// without an error.
+func (s *Service[IN, OUT]) warmup() error {
+	warmer, ok := (*s.job.Load()).(Warmer)
+	if !ok {
+		return nil
+	}
+
+	return s.retry("warmup", warmer.Warmup, s.opts.warmupAttempts)
+}
+
+// retry runs fn until it succeeds, at most given number of attempts in total, with exponential backoff between
+// attempts. Context passed to fn is canceled on shutdown.
+func (s *Service[IN, OUT]) retry(name string, fn func(ctx context.Context) error, attempts uint) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
 		select {
-		case msg := <-messages:
-			s.Debug(fmt.Sprintf("worker %d executing job", workerID))
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-			var inMsg IN
+	backoff := newBackoff(s.opts.jitter, readinessBackoff, maxReadinessBackoff)
 
-			if err := json.Unmarshal(msg.Data, &inMsg); err != nil {
-				s.Debug(fmt.Sprintf("worker %d decoding message type %T: %v", workerID, inMsg, err))
+	for attempt := uint(1); ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
 
-				continue
-			}
+		if attempt >= attempts {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		wait := backoff.next()
 
-			msg.InProgress()
+		s.Debug(fmt.Sprintf("%s attempt %d: %v, retrying in %s", name, attempt, err, wait))
 
-			outMsg := s.job.Execute(&inMsg)
+		select {
+		case <-s.opts.clock.After(wait):
+		case <-s.done:
+			return nil
+		}
+	}
+}
 
-			if outMsg == nil {
-				msg.Ack()
+// Stats returns current service stats.
+func (s *Service[IN, OUT]) Stats() Stats {
+	return s.pool.stats.stats()
+}
 
-				continue
+// exitBrokers shuts down input broker and separate output broker, if any.
+func (s *Service[IN, OUT]) exitBrokers() {
+	s.broker.Exit()
+
+	if s.opts.output != nil {
+		s.opts.output.Exit()
+	}
+}
+
+// brokerError reports error of the broker itself, as opposed to failures of processing messages.
+func (s *Service[IN, OUT]) brokerError(err error) {
+	s.pool.stats.brokerErrors.Add(1)
+	s.Debug(fmt.Sprintf("broker error: %v", err))
+
+	if s.opts.onBrokerError != nil {
+		s.opts.onBrokerError(err)
+	}
+}
+
+// subscriptionClosed reports subscription closed by the broker, unless it's closed because of shutdown.
+func (s *Service[IN, OUT]) subscriptionClosed() {
+	select {
+	case <-s.done:
+	default:
+		s.closed.Do(func() { s.brokerError(ErrSubscriptionClosed) })
+	}
+}
+
+// goForward starts forwarder of messages from the broker to workers. Forwarders are added under the same lock as
+// workers, so Drain waits for them as well.
+func (s *Service[IN, OUT]) goForward(forward func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forwarders.Add(1)
+
+	go func() {
+		defer s.forwarders.Done()
+
+		forward()
+	}()
+}
+
+// forward moves messages from the broker to the internal buffer until draining starts. It stops reading from the
+// broker then, so messages the broker keeps delivering aren't rejected in a loop, but left to the broker to be
+// delivered again once they expire or the subscription is closed.
+func (s *Service[IN, OUT]) forward(sub <-chan broker.Message, buffer chan broker.Message, flow *flowControl) {
+	defer s.nackLeftovers(buffer)
+
+	for s.consuming() {
+		select {
+		case msg, ok := <-sub:
+			if !ok {
+				s.subscriptionClosed()
+
+				return
+			}
+
+			select {
+			case buffer <- msg:
+				flow.check(len(buffer))
+			case <-s.done:
+				msg.Nack()
+
+				return
 			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// consuming reports whether draining didn't start yet.
+func (s *Service[IN, OUT]) consuming() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// nackLeftovers closes buffers, so workers stop once they processed all buffered messages if the subscription was
+// closed by the broker. Messages still left in buffers once workers finished during draining are negatively
+// acknowledged to be redelivered.
+func (s *Service[IN, OUT]) nackLeftovers(buffers ...chan broker.Message) {
+	for _, buffer := range buffers {
+		close(buffer)
+	}
+
+	<-s.idle
+
+	for _, buffer := range buffers {
+		for msg := range buffer {
+			msg.Nack()
+		}
+	}
+}
+
+// forwardPrioritized moves messages from the broker to the priority queue and from there to workers, highest
+// priority first. Queue holds at most buffer size messages. Once draining started, messages left in the queue
+// are negatively acknowledged after workers finished.
+func (s *Service[IN, OUT]) forwardPrioritized(sub <-chan broker.Message, workers chan<- broker.Message,
+	flow *flowControl,
+) {
+	defer close(workers)
+
+	queue := newPriorityQueue(s.opts.priority, s.opts.aging)
+
+	for sub != nil || queue.Len() > 0 {
+		var (
+			in   <-chan broker.Message
+			out  chan<- broker.Message
+			next broker.Message
+		)
 
-			out, err := json.Marshal(outMsg)
-			if err != nil {
-				s.Debug(fmt.Sprintf("worker %d encoding message type %T: %v", workerID, outMsg, err))
+		if queue.Len() < s.opts.bufferSize {
+			in = sub
+		}
+
+		if queue.Len() > 0 {
+			out = workers
+			next = queue.peek()
+		}
+
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				s.subscriptionClosed()
+
+				sub = nil
 
 				continue
 			}
 
-			if err := s.broker.Pub(out); err != nil {
-				s.Debug(fmt.Sprintf("worker %d publishing message %v: %v", workerID, inMsg, err))
+			queue.push(msg)
+		case out <- next:
+			queue.pop()
+		case <-s.done:
+			<-s.idle
+
+			for queue.Len() > 0 {
+				queue.pop().Nack()
 			}
 
-			msg.Ack()
+			return
+		}
+
+		flow.check(queue.Len())
+	}
+}
+
+// run executes worker taking messages from the channel until draining starts or quit is closed. Buffered returns
+// number of messages waiting for workers, which is checked against flow control watermarks.
+func (s *Service[IN, OUT]) run(workerID uint16, messages <-chan broker.Message, flow *flowControl,
+	buffered func() int, quit <-chan struct{},
+) {
+	defer s.emit(WorkerStopped, workerID, nil)
+
+	s.Debug(fmt.Sprintf("starting worker %d", workerID))
+	s.emit(WorkerStarted, workerID, nil)
+
+	for {
+		// Don't take new messages once draining started, even if some are buffered.
+		select {
 		case <-s.done:
 			s.Debug(fmt.Sprintf("stopping worker %d", workerID))
-			s.wg.Done()
 
-			for range messages {
-				<-messages
+			return
+		default:
+		}
+
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				s.subscriptionClosed()
+
+				return
 			}
 
+			flow.check(buffered())
+
+			s.process(workerID, msg)
+		case <-s.done:
+			s.Debug(fmt.Sprintf("stopping worker %d", workerID))
+
+			return
+		case <-quit:
+			s.Debug(fmt.Sprintf("stopping worker %d", workerID))
+
 			return
 		}
 	}
 }
 
+// process decodes message, executes the job, publishes its output and acknowledges the message.
+func (s *Service[IN, OUT]) process(workerID uint16, msg broker.Message) {
+	if err := msg.Err(); err != nil {
+		s.brokerError(err)
+
+		return
+	}
+
+	s.opts.metrics.Consumed()
+
+	logCtx := s.logContext(msg)
+
+	var hash [sha256.Size]byte
+
+	if s.dedup != nil {
+		if hash = s.dedup.hash(msg.Data); s.dedup.contains(hash) {
+			s.Debug(fmt.Sprintf("worker %d skipping duplicate message%s", workerID, logCtx))
+			msg.Ack()
+
+			return
+		}
+	}
+
+	if s.opts.ackOnReceive {
+		msg.Ack()
+
+		// Nothing is left to acknowledge or extend.
+		msg.Ack, msg.Nack, msg.InProgress, msg.Extend = func() {}, func() {}, func() {}, nil
+	}
+
+	if len(msg.Data) == 0 && s.skipEmpty(workerID, msg, logCtx) {
+		return
+	}
+
+	s.pool.execute(delivery{workerID: workerID, msg: msg, logCtx: logCtx, hash: hash})
+}
+
+// delivery is message taken by a worker, executed by the pool of the service.
+type delivery struct {
+	workerID uint16
+	msg      broker.Message
+	logCtx   string
+	hash     [sha256.Size]byte
+}
+
+// execute executes the job, publishes its output and acknowledges the message. Failures are handled here, so it
+// never returns an error to the pool.
+func (s *Service[IN, OUT]) execute(ctx context.Context, delivered delivery) error {
+	workerID, msg, logCtx := delivered.workerID, delivered.msg, delivered.logCtx
+
+	s.Debug(fmt.Sprintf("worker %d executing job%s", workerID, logCtx))
+
+	var state processing[IN]
+
+	if s.acks != nil {
+		state.ack = s.acks.add(msg)
+	}
+
+	handler := chain(s.opts.middleware, func(ctx context.Context, msg broker.Message) ([]byte, error) {
+		return s.handle(ctx, workerID, msg, logCtx, &state)
+	})
+
+	output, err := handler(ctx, msg)
+
+	if state.buf != nil {
+		defer bufferPool.Put(state.buf)
+	}
+
+	defer state.routes.release()
+
+	if err != nil {
+		state.release()
+		s.failed(workerID, msg, err, logCtx)
+
+		return nil
+	}
+
+	if err := s.publishOutput(msg, output, state.routes); err != nil {
+		state.release()
+		s.Debug(fmt.Sprintf("worker %d publishing message type %T: %v%s%s", workerID, (*OUT)(nil), err, logCtx,
+			s.logFields(state.input)))
+		s.emit(MessageFailed, workerID, fmt.Errorf("publish: %w", err))
+		s.opts.metrics.Failed(workerID)
+
+		if !s.fail(workerID, msg, fmt.Errorf("publish: %w", err), logCtx) && !s.opts.ackOnConfirm {
+			msg.Ack()
+		}
+
+		return nil
+	}
+
+	// In manual ack mode, job acknowledges the message on its own.
+	if state.ack == nil {
+		msg.Ack()
+	}
+
+	s.complete(workerID, state.stats, delivered.hash)
+
+	return nil
+}
+
+// processing contains state of processing a single message shared by handle and process.
+type processing[IN any] struct {
+	stats  MessageStats
+	buf    *bytes.Buffer
+	routes encodedRoutes
+	input  *IN
+	// Acknowledgement left to the job, nil unless in manual ack mode.
+	ack *manualAck
+}
+
+// release stops waiting for the job to acknowledge failed message, settled by the service instead.
+func (p *processing[IN]) release() {
+	if p.ack != nil {
+		p.ack.release()
+	}
+}
+
+// handle decodes message, executes the job and encodes its output. Nil output means there is nothing to publish
+// except routes kept in state. Encoded output is valid until state buffers are returned to the pool.
+func (s *Service[IN, OUT]) handle(ctx context.Context, workerID uint16, msg broker.Message, logCtx string,
+	state *processing[IN],
+) ([]byte, error) {
+	tombstone := len(msg.Data) == 0
+	_, discarded := s.opts.metrics.(noMetrics)
+	watch := newStopwatch(s.opts.onComplete != nil || !discarded)
+
+	var inMsg IN
+
+	state.input = &inMsg
+	input := &inMsg
+
+	if tombstone {
+		input = nil
+	} else if err := decodeMessage(s.opts, msg.Headers(), msg.Data, &inMsg); err != nil {
+		s.Debug(fmt.Sprintf("worker %d decoding message type %T: %v%s%s", workerID, inMsg, err, logCtx,
+			s.payload(msg.Data)))
+
+		return nil, handled{fmt.Errorf("decode: %w", err)}
+	}
+
+	state.stats.Decode = watch.lap()
+
+	msg.InProgress()
+
+	stopExtend := s.extend(msg)
+	defer stopExtend()
+
+	acquired, ok := s.acquire(msg)
+	if !ok {
+		return nil, errShutdown
+	}
+
+	// Released also if execution panics and middleware recovers.
+	var released sync.Once
+
+	release := func() { released.Do(acquired) }
+	defer release()
+
+	var compare func(primary *OUT) (string, error)
+
+	if s.shadow != nil && !tombstone {
+		compare = s.shadow.execute(s.opts, msg)
+	}
+
+	ctx = context.WithValue(ctx, extenderKey{}, msg)
+	ctx = context.WithValue(ctx, emitterKey{}, Emitter(s))
+
+	if state.ack != nil {
+		ctx = context.WithValue(ctx, ackKey{}, state.ack.ack)
+	}
+
+	outMsg, err := executeOne(ctx, *s.job.Load(), s.opts, input)
+
+	release()
+
+	if err != nil {
+		s.Debug(fmt.Sprintf("worker %d %v%s%s", workerID, err, logCtx, s.logFields(&inMsg)))
+
+		return nil, handled{err}
+	}
+
+	state.stats.Execute = watch.lap()
+
+	if compare != nil {
+		s.compareShadow(workerID, compare, outMsg, logCtx)
+	}
+
+	if outMsg == nil {
+		return nil, nil
+	}
+
+	if router, ok := any(outMsg).(Router); ok {
+		if state.routes, err = encodeRoutes(s.opts.encoder, router.Routes()); err != nil {
+			s.Debug(fmt.Sprintf("worker %d encoding routed message type %T: %v%s%s", workerID, outMsg, err, logCtx,
+				s.logFields(&inMsg)))
+
+			return nil, handled{fmt.Errorf("encode: %w", err)}
+		}
+
+		state.stats.Encode = watch.lap()
+
+		return nil, nil
+	}
+
+	buf, err := encode(s.opts.encoder, outMsg)
+	if err != nil {
+		s.Debug(fmt.Sprintf("worker %d encoding message type %T: %v%s%s", workerID, outMsg, err, logCtx,
+			s.logFields(&inMsg)))
+
+		return nil, handled{fmt.Errorf("encode: %w", err)}
+	}
+
+	state.buf = buf
+	state.stats.Encode = watch.lap()
+
+	return buf.Bytes(), nil
+}
+
+// failed handles message which failed to be handled.
+func (s *Service[IN, OUT]) failed(workerID uint16, msg broker.Message, err error, logCtx string) {
+	if errors.Is(err, errShutdown) {
+		msg.Nack()
+
+		return
+	}
+
+	if !errors.As(err, new(handled)) {
+		s.Debug(fmt.Sprintf("worker %d handling message: %v%s", workerID, err, logCtx))
+	}
+
+	s.emit(MessageFailed, workerID, err)
+	s.opts.metrics.Failed(workerID)
+
+	if s.dispose(workerID, msg, err, logCtx) {
+		return
+	}
+
+	// Canceled execution isn't a permanent failure, so it's retried right away instead of being dead-lettered,
+	// unless the message keeps timing out.
+	if (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && !s.timedOut(msg, err) {
+		msg.Nack()
+
+		return
+	}
+
+	s.fail(workerID, msg, err, logCtx)
+}
+
+// timedOut counts execute timeouts of the message and reports whether it ran out of timeout attempts.
+func (s *Service[IN, OUT]) timedOut(msg broker.Message, err error) bool {
+	id := msg.ID()
+	if s.timeouts == nil || id == "" || !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if s.timeouts.update(id, func(attempts uint) uint { return attempts + 1 }) < s.opts.timeoutAttempts {
+		return false
+	}
+
+	s.timeouts.forget(id)
+
+	return true
+}
+
+// compareShadow waits for shadow job output and reports differences from the primary output.
+func (s *Service[IN, OUT]) compareShadow(workerID uint16, compare func(primary *OUT) (string, error), outMsg *OUT,
+	logCtx string,
+) {
+	diff, err := compare(outMsg)
+	if err != nil {
+		s.Debug(fmt.Sprintf("worker %d shadow job: %v%s", workerID, err, logCtx))
+
+		return
+	}
+
+	if diff != "" {
+		s.pool.stats.shadowDiffs.Add(1)
+		s.Debug(fmt.Sprintf("worker %d shadow output differs: %s%s", workerID, diff, logCtx))
+	}
+}
+
+// extenderKey is context key of the message which ack deadline is extended by ExtendAck.
+type extenderKey struct{}
+
+// ExtendAck extends ack deadline of the message being processed to d from now, so slow job isn't redelivered
+// while still running. It's meant to be called by jobs implementing ContextJob with the context they received.
+// Brokers not able to set the deadline, like NATS JetStream, restart their ack wait regardless of d. It's no-op
+// for brokers extending the deadline on their own and if ctx doesn't belong to an executed message.
+func ExtendAck(ctx context.Context, d time.Duration) {
+	msg, ok := ctx.Value(extenderKey{}).(broker.Message)
+	if !ok {
+		return
+	}
+
+	if msg.Extend != nil {
+		msg.Extend(d)
+
+		return
+	}
+
+	msg.InProgress()
+}
+
+// extend periodically reports message in progress until returned function is called.
+func (s *Service[IN, OUT]) extend(msg broker.Message) func() {
+	if s.opts.extendInterval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(s.opts.extendInterval)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-ticker.C:
+				msg.InProgress()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		<-stopped
+	}
+}
+
+// acquire waits for a slot of the message key if limited by WithKeyLimit. It reports false if shutdown started
+// meanwhile.
+func (s *Service[IN, OUT]) acquire(msg broker.Message) (func(), bool) {
+	if s.limiter == nil {
+		return func() {}, true
+	}
+
+	key := s.opts.limitKey(msg)
+	if key == "" {
+		return func() {}, true
+	}
+
+	return s.limiter.acquire(key, s.done)
+}
+
+// skipEmpty handles zero-length message according to the policy. It reports whether the message was handled
+// and shouldn't be delivered to the job.
+func (s *Service[IN, OUT]) skipEmpty(workerID uint16, msg broker.Message, logCtx string) bool {
+	switch s.opts.emptyPolicy {
+	case DeliverEmpty:
+		return false
+	case FailEmpty:
+		s.Debug(fmt.Sprintf("worker %d empty message%s", workerID, logCtx))
+		s.emit(MessageFailed, workerID, ErrEmptyMessage)
+		s.opts.metrics.Failed(workerID)
+		s.fail(workerID, msg, ErrEmptyMessage, logCtx)
+	case SkipEmpty:
+		s.Debug(fmt.Sprintf("worker %d skipping empty message%s", workerID, logCtx))
+		msg.Ack()
+	}
+
+	return true
+}
+
+// complete reports successfully processed message.
+func (s *Service[IN, OUT]) complete(workerID uint16, stats MessageStats, hash [sha256.Size]byte) {
+	if s.dedup != nil {
+		s.dedup.add(hash)
+	}
+
+	s.emit(MessageProcessed, workerID, nil)
+	s.opts.metrics.Processed(workerID, stats)
+
+	if s.opts.onComplete != nil {
+		s.opts.onComplete(stats)
+	}
+}
+
+// emitterKey is context key of the Emitter returned by EmitterFrom.
+type emitterKey struct{}
+
+// EmitterFrom returns Emitter of the service executing the job, so jobs implementing ContextJob can send audit or
+// log events while executing without their own broker handle. It returns nil if ctx doesn't belong to an executed
+// message.
+func EmitterFrom(ctx context.Context) Emitter {
+	emitter, _ := ctx.Value(emitterKey{}).(Emitter)
+
+	return emitter
+}
+
+// Emit encodes v and immediately publishes it to the given topic, independently of the job output and with the
+// same publish retries. Brokers don't take context, so ctx is only checked before publishing, like by Publish.
+func (s *Service[IN, OUT]) Emit(ctx context.Context, topic string, v any) error {
+	return s.PublishTo(ctx, topic, v)
+}
+
+// Publish encodes v the same way as job output and publishes it to the default topic without consuming anything,
+// for example to seed a stream from initialization code. It can be used before Run. Brokers don't take context, so
+// ctx is only checked before publishing.
+func (s *Service[IN, OUT]) Publish(ctx context.Context, v any) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	return s.publish(v)
+}
+
+// PublishTo is Publish to the given topic instead of the default one.
+func (s *Service[IN, OUT]) PublishTo(ctx context.Context, topic string, v any) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	return s.publish(v, broker.WithSubject(topic))
+}
+
+func (s *Service[IN, OUT]) publish(v any, opts ...broker.PubOption) error {
+	buf, err := encode(s.opts.encoder, v)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	defer bufferPool.Put(buf)
+
+	if s.opts.contentType != "" {
+		opts = append(opts, broker.WithHeader(ContentTypeHeader, s.opts.contentType))
+	}
+
+	if err := s.pub(buf.Bytes(), opts); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	return nil
+}
+
+// decode decodes JSON data into v. If v is a byte slice pointer, which is the case for jobs with []byte input,
+// data is passed through as is.
+func decode(data []byte, v any) error {
+	if raw, ok := v.(*[]byte); ok {
+		*raw = data
+
+		return nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	return nil
+}
+
+// encode encodes v as JSON into a pooled buffer. Caller has to put the buffer back to the pool once done.
+// Byte slices, used by jobs with []byte output, bypass encoding.
+func encode(encoder PayloadEncoder, v any) (*bytes.Buffer, error) {
+	buf, _ := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	switch raw := v.(type) {
+	case []byte:
+		buf.Write(raw)
+
+		return buf, nil
+	case *[]byte:
+		buf.Write(*raw)
+
+		return buf, nil
+	}
+
+	if err := encoder(buf, v); err != nil {
+		bufferPool.Put(buf)
+
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// logContext formats configured correlation headers of the message as log fields.
+func (s *Service[IN, OUT]) logContext(msg broker.Message) string {
+	headers := msg.Headers()
+	if len(headers) == 0 {
+		return ""
+	}
+
+	fields := make([]string, 0, len(s.opts.correlationHeaders))
+
+	for key, field := range s.opts.correlationHeaders {
+		if value, ok := headers[key]; ok {
+			fields = append(fields, fmt.Sprintf(" %s=%s", field, value))
+		}
+	}
+
+	sort.Strings(fields)
+
+	return strings.Join(fields, "")
+}
+
+// payload formats message data as a log field if enabled by WithPayloadLogging.
+func (s *Service[IN, OUT]) payload(data []byte) string {
+	if s.opts.payloadLogLimit <= 0 {
+		return ""
+	}
+
+	if len(data) > s.opts.payloadLogLimit {
+		return fmt.Sprintf(" payload=%q... (%d bytes)", data[:s.opts.payloadLogLimit], len(data))
+	}
+
+	return fmt.Sprintf(" payload=%q", data)
+}
+
+// logFieldsOf returns log fields set by options, nil if there are none.
+func logFieldsOf[IN any](opts *options) func(msg *IN) map[string]string {
+	if opts.logFields == nil {
+		return nil
+	}
+
+	fields, ok := opts.logFields.(func(msg *IN) map[string]string)
+	if !ok {
+		panic(fmt.Sprintf("service: log fields message type doesn't match the service job, got %T", opts.logFields))
+	}
+
+	return fields
+}
+
+// logFields formats fields set by WithLogFields, if any, as log fields.
+func (s *Service[IN, OUT]) logFields(msg *IN) string {
+	if s.fields == nil || msg == nil {
+		return ""
+	}
+
+	fields := s.fields(msg)
+	formatted := make([]string, 0, len(fields))
+
+	for key, value := range fields {
+		formatted = append(formatted, fmt.Sprintf(" %s=%s", key, value))
+	}
+
+	sort.Strings(formatted)
+
+	return strings.Join(formatted, "")
+}
+
+// pubOptions returns options for publishing output of the message with the given index. Output is routed to the
+// reply subject of the message, if any, and carries its key unless key propagation is disabled.
+func (s *Service[IN, OUT]) pubOptions(msg broker.Message, index int) []broker.PubOption {
+	var opts []broker.PubOption
+
+	if key := msg.Key(); key != nil && !s.opts.noKeyPropagation {
+		opts = append(opts, broker.WithKey(key))
+	}
+
+	if id := msg.ID(); id != "" && s.opts.idempotencyKey != nil {
+		opts = append(opts, broker.WithIdempotencyKey(s.opts.idempotencyKey(id, index)))
+	}
+
+	if s.opts.contentType != "" {
+		opts = append(opts, broker.WithHeader(ContentTypeHeader, s.opts.contentType))
+	}
+
+	headers := msg.Headers()
+
+	replyTo, ok := headers[ReplyToHeader]
+	if !ok || replyTo == "" {
+		return opts
+	}
+
+	opts = append(opts, broker.WithSubject(replyTo))
+
+	if correlationID, ok := headers[CorrelationIDHeader]; ok {
+		opts = append(opts, broker.WithHeader(CorrelationIDHeader, correlationID))
+	}
+
+	return opts
+}
+
+// IdempotencyKey derives idempotency key of the output from ID of the input message and index of the output.
+func IdempotencyKey(id string, index int) string {
+	return id + "/" + strconv.Itoa(index)
+}
+
+// exitHooks are run by Exit before terminating the process.
+var exitHooks struct { //nolint:gochecknoglobals
+	sync.Mutex
+	hooks []func()
+}
+
+// OnExit registers a hook run by Exit before terminating the process, like flushing buffered metrics, traces or
+// logs. Hooks run in order of registration and all of them together are bounded by a short timeout, so a stuck
+// flush can't prevent termination.
+func OnExit(hook func()) {
+	exitHooks.Lock()
+	defer exitHooks.Unlock()
+
+	exitHooks.hooks = append(exitHooks.hooks, hook)
+}
+
 // Exit exits CLI application writing message and error to stderr.
 func Exit(message string, err error) {
 	fmt.Fprintf(os.Stderr, "%s: %v\n", message, err)
+	runExitHooks()
 	os.Exit(1)
 }
+
+func runExitHooks() {
+	exitHooks.Lock()
+	hooks := exitHooks.hooks
+	exitHooks.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for _, hook := range hooks {
+			hook()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(exitHooksTimeout):
+		fmt.Fprintf(os.Stderr, "exit hooks timed out after %s\n", exitHooksTimeout)
+	}
+}
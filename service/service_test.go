@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+func TestNewServicePanicsOnMissingDependency(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		broker broker.Broker
+		job    service.Job[int, int]
+		want   error
+	}{
+		"broker": {broker: nil, job: echoJob{}, want: service.ErrMissingBroker},
+		"job":    {broker: servicetest.NewBroker(), job: nil, want: service.ErrMissingJob},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if recovered := recover(); recovered == nil || !strings.Contains(recovered.(string), test.want.Error()) {
+					t.Errorf("want panic naming %q, got %v", test.want, recovered)
+				}
+			}()
+
+			service.NewService(test.broker, test.job)
+		})
+	}
+}
+
+func TestBuildFailsOnMissingDependency(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config service.Config[int, int]
+		want   error
+	}{
+		"broker": {
+			config: service.Config[int, int]{Job: echoJob{}}, //nolint:exhaustruct
+			want:   service.ErrMissingBroker,
+		},
+		"job": {
+			config: service.Config[int, int]{Broker: servicetest.NewBroker()}, //nolint:exhaustruct
+			want:   service.ErrMissingJob,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := test.config.Build(); !errors.Is(err, test.want) {
+				t.Errorf("want %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestBuildValidatesRetriesAndTimeouts(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]service.Config[int, int]{
+		"backoff without retries": {RetryBackoff: time.Second},     //nolint:exhaustruct
+		"retries without backoff": {PublishRetries: 3},             //nolint:exhaustruct
+		"negative backoff":        {RetryBackoff: -time.Second},    //nolint:exhaustruct
+		"negative shutdown":       {ShutdownTimeout: -time.Second}, //nolint:exhaustruct
+		"negative execute":        {ExecuteTimeout: -time.Second},  //nolint:exhaustruct
+	}
+
+	for name, config := range tests {
+		config := config
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config.Broker, config.Job = servicetest.NewBroker(), echoJob{}
+
+			if _, err := config.Build(); !errors.Is(err, service.ErrInvalidConfig) {
+				t.Errorf("want %v, got %v", service.ErrInvalidConfig, err)
+			}
+		})
+	}
+
+	valid := service.Config[int, int]{ //nolint:exhaustruct
+		Broker:          servicetest.NewBroker(),
+		Job:             echoJob{},
+		ShutdownTimeout: time.Second,
+		ExecuteTimeout:  time.Second,
+		PublishRetries:  3,
+		RetryBackoff:    time.Millisecond,
+		NackOnFailure:   true,
+	}
+
+	if _, err := valid.Build(); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package servicetest
+
+import (
+	"sync"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+var _ broker.Broker = (*Broker)(nil)
+
+// Broker is in-memory broker.Broker delivering predefined messages and recording outcomes.
+// Subscription is closed once all messages are delivered.
+type Broker struct {
+	messages  [][]byte
+	keys      [][]byte
+	headers   []map[string]string
+	mu        sync.Mutex
+	published []Published
+	acked     []int
+	nacked    []int
+}
+
+// Published contains message published to Broker.
+type Published struct {
+	Data    []byte
+	Options *broker.PubOptions
+}
+
+// NewBroker creates Broker delivering given messages in order.
+func NewBroker(messages ...[]byte) *Broker {
+	return &Broker{messages: messages} //nolint:exhaustruct
+}
+
+// WithKeys sets keys of messages at the same index and returns the broker.
+func (b *Broker) WithKeys(keys ...[]byte) *Broker {
+	b.keys = keys
+
+	return b
+}
+
+// WithHeaders sets headers of messages at the same index and returns the broker.
+func (b *Broker) WithHeaders(headers ...map[string]string) *Broker {
+	b.headers = headers
+
+	return b
+}
+
+// Sub implements broker.Broker interface.
+func (b *Broker) Sub() (<-chan broker.Message, error) {
+	messages := make(chan broker.Message, len(b.messages))
+
+	for i, data := range b.messages {
+		i := i
+
+		msg := broker.Message{ //nolint:exhaustruct
+			Data:       data,
+			Ack:        func() { b.record(&b.acked, i) },
+			Nack:       func() { b.record(&b.nacked, i) },
+			InProgress: func() {},
+		}
+
+		if i < len(b.keys) {
+			msg = msg.WithKey(b.keys[i])
+		}
+
+		if i < len(b.headers) {
+			msg = msg.WithHeaders(b.headers[i])
+		}
+
+		messages <- msg
+	}
+
+	close(messages)
+
+	return messages, nil
+}
+
+// Pub implements broker.Broker interface.
+func (b *Broker) Pub(message []byte, opts ...broker.PubOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.published = append(b.published, Published{
+		Data:    append([]byte(nil), message...),
+		Options: broker.NewPubOptions(opts...),
+	})
+
+	return nil
+}
+
+// Exit implements broker.Broker interface.
+func (b *Broker) Exit() {}
+
+// Published returns published messages in order.
+func (b *Broker) Published() []Published {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Published(nil), b.published...)
+}
+
+// Acked returns indexes of acknowledged messages in order of acknowledgement.
+func (b *Broker) Acked() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]int(nil), b.acked...)
+}
+
+// Nacked returns indexes of negatively acknowledged messages in order of acknowledgement.
+func (b *Broker) Nacked() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]int(nil), b.nacked...)
+}
+
+func (b *Broker) record(indexes *[]int, index int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	*indexes = append(*indexes, index)
+}
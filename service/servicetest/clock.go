@@ -0,0 +1,194 @@
+package servicetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.ectobit.com/oxeye/service"
+)
+
+var _ service.Clock = (*Clock)(nil)
+
+// Clock is service.Clock which moves only when Advance is called. It's safe for concurrent use.
+type Clock struct {
+	mu     sync.Mutex
+	added  *sync.Cond
+	now    time.Time
+	timers []*timer
+	waits  []time.Duration
+}
+
+// timer fires once the clock reaches its time.
+type timer struct {
+	at   time.Time
+	fire func(now time.Time)
+}
+
+// NewClock creates clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	clock := &Clock{now: now} //nolint:exhaustruct
+	clock.added = sync.NewCond(&clock.mu)
+
+	return clock
+}
+
+// Now implements service.Clock interface.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After implements service.Clock interface.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	fired := make(chan time.Time, 1)
+
+	c.add(d, func(now time.Time) { fired <- now })
+
+	return fired
+}
+
+// WithTimeout implements service.Clock interface.
+func (c *Clock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx := &timeoutContext{ //nolint:exhaustruct
+		Context:  parent,
+		deadline: c.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+
+	timer := c.add(d, func(time.Time) { ctx.cancel(context.DeadlineExceeded) })
+
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx.cancel(parent.Err())
+		case <-ctx.done:
+		}
+	}()
+
+	return ctx, func() {
+		c.remove(timer)
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// Advance moves the clock forward, firing all timers which are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+
+	c.now = c.now.Add(d)
+	now := c.now
+
+	var due, pending []*timer
+
+	for _, timer := range c.timers {
+		if timer.at.After(now) {
+			pending = append(pending, timer)
+
+			continue
+		}
+
+		due = append(due, timer)
+	}
+
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+// BlockUntil waits until at least the given number of timers are waiting for the clock.
+func (c *Clock) BlockUntil(timers int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < timers {
+		c.added.Wait()
+	}
+}
+
+// Waits returns durations requested by After and WithTimeout in order of calls.
+func (c *Clock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]time.Duration(nil), c.waits...)
+}
+
+func (c *Clock) add(d time.Duration, fire func(now time.Time)) *timer {
+	c.mu.Lock()
+
+	c.waits = append(c.waits, d)
+
+	if d <= 0 {
+		now := c.now
+		c.mu.Unlock()
+
+		fire(now)
+
+		return nil
+	}
+
+	timer := &timer{at: c.now.Add(d), fire: fire}
+	c.timers = append(c.timers, timer)
+	c.added.Broadcast()
+	c.mu.Unlock()
+
+	return timer
+}
+
+func (c *Clock) remove(removed *timer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, timer := range c.timers {
+		if timer == removed {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// timeoutContext is canceled once its timer fires, its parent is done or it's canceled.
+type timeoutContext struct {
+	context.Context //nolint:containedctx
+	deadline        time.Time
+	done            chan struct{}
+	once            sync.Once
+	mu              sync.Mutex
+	err             error
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	if parent, ok := c.Context.Deadline(); ok && parent.Before(c.deadline) {
+		return parent, true
+	}
+
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *timeoutContext) cancel(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.done)
+	})
+}
@@ -0,0 +1,27 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+)
+
+// RunSequential runs service with single worker receiving directly from the broker, so each message is processed
+// completely before the next one is received, until the broker closes the subscription. It's meant for tests which
+// need to assert ordering and state without sleeps. Concurrency and buffer size set by opts are overridden, as
+// well as broker error callback, other options apply as usual.
+func RunSequential[IN, OUT any](br broker.Broker, job service.Job[IN, OUT], opts ...service.Option) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts = append(opts, service.WithConcurrency(1), service.WithBufferSize(0),
+		service.WithOnBrokerError(func(err error) {
+			if errors.Is(err, service.ErrSubscriptionClosed) {
+				cancel()
+			}
+		}))
+
+	return service.NewService(br, job, opts...).RunContext(ctx) //nolint:wrapcheck
+}
@@ -0,0 +1,106 @@
+// Package servicetest contains helpers for testing jobs executed by the service.
+package servicetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// AssertRoundTrip encodes value the same way service does, decodes it back and reports every field which didn't
+// survive the round trip, like unexported fields or fields hidden by struct tags.
+func AssertRoundTrip[T any](t testing.TB, value T) {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Errorf("encode %T: %v", value, err)
+
+		return
+	}
+
+	var decoded T
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Errorf("decode %T: %v", value, err)
+
+		return
+	}
+
+	for _, lost := range compare(reflect.TypeOf(value).String(), reflect.ValueOf(value), reflect.ValueOf(decoded)) {
+		t.Errorf("round trip lost %s", lost)
+	}
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem() //nolint:gochecknoglobals
+
+// compare returns paths of all differences between original and decoded value.
+func compare(path string, original, decoded reflect.Value) []string { //nolint:cyclop
+	if !original.IsValid() || !decoded.IsValid() {
+		if original.IsValid() != decoded.IsValid() {
+			return []string{path}
+		}
+
+		return nil
+	}
+
+	if original.Type().Implements(marshalerType) {
+		return compareEncoded(path, original, decoded)
+	}
+
+	switch original.Kind() { //nolint:exhaustive
+	case reflect.Pointer, reflect.Interface:
+		if original.IsNil() || decoded.IsNil() {
+			if original.IsNil() != decoded.IsNil() {
+				return []string{path}
+			}
+
+			return nil
+		}
+
+		return compare(path, original.Elem(), decoded.Elem())
+	case reflect.Struct:
+		var lost []string
+
+		for i := 0; i < original.NumField(); i++ {
+			field := original.Type().Field(i)
+			fieldPath := path + "." + field.Name
+
+			if !field.IsExported() {
+				if !original.Field(i).IsZero() {
+					lost = append(lost, fieldPath+" (unexported)")
+				}
+
+				continue
+			}
+
+			lost = append(lost, compare(fieldPath, original.Field(i), decoded.Field(i))...)
+		}
+
+		return lost
+	default:
+		if !reflect.DeepEqual(original.Interface(), decoded.Interface()) {
+			return []string{fmt.Sprintf("%s: want %v, got %v", path, original.Interface(), decoded.Interface())}
+		}
+
+		return nil
+	}
+}
+
+// compareEncoded compares values which define their own encoding by their encoded form.
+func compareEncoded(path string, original, decoded reflect.Value) []string {
+	if !original.CanInterface() {
+		return nil
+	}
+
+	want, wantErr := json.Marshal(original.Interface())
+	got, gotErr := json.Marshal(decoded.Interface())
+
+	if wantErr != nil || gotErr != nil || !bytes.Equal(want, got) {
+		return []string{fmt.Sprintf("%s: want %s, got %s", path, want, got)}
+	}
+
+	return nil
+}
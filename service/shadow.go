@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"go.ectobit.com/oxeye/broker"
+)
+
+// Errors.
+var (
+	ErrShadowPanic = errors.New("shadow job panicked")
+)
+
+// shadow is a job executed alongside the primary one with its output compared instead of published.
+type shadow[IN, OUT any] struct {
+	job     Job[IN, OUT]
+	compare func(primary, shadow *OUT) string
+}
+
+// WithShadow runs a second job on each message concurrently with the primary one, for example a new version
+// validated against live traffic. Shadow output is never published, instead compare receives both outputs and
+// returns description of their differences, or an empty string if they are equivalent. Differences are logged
+// and counted in Stats. Panic of the shadow job is recovered and logged as comparison error. Worker waits for
+// both jobs, so a slow shadow slows down the service. Job types have to match the service, NewService panics
+// otherwise.
+func WithShadow[IN, OUT any](job Job[IN, OUT], compare func(primary, shadow *OUT) string) Option {
+	return func(o *options) {
+		o.shadow = &shadow[IN, OUT]{job: job, compare: compare}
+	}
+}
+
+// shadowOf returns shadow set by options, nil if there is none.
+func shadowOf[IN, OUT any](opts *options) *shadow[IN, OUT] {
+	if opts.shadow == nil {
+		return nil
+	}
+
+	shadow, ok := opts.shadow.(*shadow[IN, OUT])
+	if !ok {
+		panic(fmt.Sprintf("service: shadow job types don't match the service job, got %T", opts.shadow))
+	}
+
+	return shadow
+}
+
+// execute starts shadow job on its own copy of the input. Returned function waits for the shadow output and
+// compares it to the primary one.
+func (s *shadow[IN, OUT]) execute(opts *options, msg broker.Message) func(primary *OUT) (string, error) {
+	results := make(chan *OUT, 1)
+	panics := make(chan error, 1)
+
+	var inMsg IN
+
+	if err := decodeMessage(opts, msg.Headers(), msg.Data, &inMsg); err != nil {
+		return func(*OUT) (string, error) { return "", fmt.Errorf("decode: %w", err) }
+	}
+
+	go func() {
+		// Shadow job runs outside of the middleware, so its panic would crash the service.
+		defer func() {
+			if r := recover(); r != nil {
+				panics <- fmt.Errorf("%w: %v", ErrShadowPanic, r)
+			}
+		}()
+
+		results <- s.job.Execute(&inMsg)
+	}()
+
+	return func(primary *OUT) (string, error) {
+		select {
+		case result := <-results:
+			return s.compare(primary, result), nil
+		case err := <-panics:
+			return "", err
+		}
+	}
+}
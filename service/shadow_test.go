@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"testing"
+
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+type panicJob struct{}
+
+func (panicJob) Execute(*int) *int {
+	panic("shadow failure")
+}
+
+func TestShadowPanicIsRecovered(t *testing.T) {
+	t.Parallel()
+
+	br := servicetest.NewBroker([]byte("1"), []byte("2"))
+	srv := service.NewService[int, int](br, echoJob{}, service.WithConcurrency(1),
+		service.WithShadow[int, int](panicJob{}, func(_, _ *int) string { return "" }))
+
+	go func() {
+		settled(br, 2)
+		srv.Drain()
+	}()
+
+	if err := srv.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acked := len(br.Acked()); acked != 2 {
+		t.Errorf("want 2 acknowledged messages, got %d", acked)
+	}
+
+	if published := len(br.Published()); published != 2 {
+		t.Errorf("want 2 published messages, got %d", published)
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const shutdownHookTimeout = 5 * time.Second
+
+// ShutdownPhase identifies stage of graceful shutdown.
+type ShutdownPhase uint8
+
+// Shutdown phases in order.
+const (
+	// DrainStarted is entered once shutdown was requested, before anything is stopped.
+	DrainStarted ShutdownPhase = iota + 1
+	// ConsumptionStopped is entered once workers stopped taking new messages.
+	ConsumptionStopped
+	// InFlightComplete is entered once messages being processed finished, canceled jobs included.
+	InFlightComplete
+	// BrokerFlushed is entered once brokers were shut down.
+	BrokerFlushed
+	// WorkersStopped is entered at the very end of shutdown.
+	WorkersStopped
+)
+
+// String implements fmt.Stringer interface.
+func (p ShutdownPhase) String() string {
+	switch p {
+	case DrainStarted:
+		return "drain started"
+	case ConsumptionStopped:
+		return "consumption stopped"
+	case InFlightComplete:
+		return "in-flight complete"
+	case BrokerFlushed:
+		return "broker flushed"
+	case WorkersStopped:
+		return "workers stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// WithShutdownHook registers hook run when shutdown enters the phase, like deregistering from a load balancer once
+// drain started. Shutdown waits for hooks of each phase in order of registration, each at most 5s, after which
+// hook's context is canceled and shutdown continues.
+func WithShutdownHook(phase ShutdownPhase, hook func(ctx context.Context)) Option {
+	return func(o *options) {
+		if o.shutdownHooks == nil {
+			o.shutdownHooks = make(map[ShutdownPhase][]func(ctx context.Context))
+		}
+
+		o.shutdownHooks[phase] = append(o.shutdownHooks[phase], hook)
+	}
+}
+
+// enterPhase runs shutdown hooks of the phase.
+func (s *Service[IN, OUT]) enterPhase(phase ShutdownPhase) {
+	s.Debug(fmt.Sprintf("shutdown phase: %s", phase))
+
+	for _, hook := range s.opts.shutdownHooks[phase] {
+		runShutdownHook(hook, func() {
+			s.Debug(fmt.Sprintf("shutdown hook of phase %s timed out after %s", phase, shutdownHookTimeout))
+		})
+	}
+}
+
+func runShutdownHook(hook func(ctx context.Context), timedOut func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownHookTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		hook(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		timedOut()
+	}
+}
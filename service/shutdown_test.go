@@ -0,0 +1,188 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.ectobit.com/oxeye/broker"
+	"go.ectobit.com/oxeye/service"
+	"go.ectobit.com/oxeye/service/servicetest"
+)
+
+type slowJob struct {
+	delay time.Duration
+}
+
+func (j slowJob) Execute(msg *int) *int {
+	time.Sleep(j.delay)
+
+	return msg
+}
+
+func (j slowJob) ExecuteContext(ctx context.Context, msg *int) *int {
+	select {
+	case <-time.After(j.delay):
+	case <-ctx.Done():
+	}
+
+	return msg
+}
+
+// openBroker delivers messages without ever closing the subscription.
+type openBroker struct {
+	messages chan broker.Message
+}
+
+func newOpenBroker() *openBroker {
+	return &openBroker{messages: make(chan broker.Message)}
+}
+
+func (b *openBroker) Sub() (<-chan broker.Message, error) { return b.messages, nil }
+
+func (b *openBroker) Pub([]byte, ...broker.PubOption) error { return nil }
+
+func (b *openBroker) Exit() {}
+
+func (b *openBroker) send(data string) {
+	b.messages <- broker.Message{ //nolint:exhaustruct
+		Data:       []byte(data),
+		Ack:        func() {},
+		Nack:       func() {},
+		InProgress: func() {},
+	}
+}
+
+func TestShutdownDeadlineWaitsForCanceledJobs(t *testing.T) {
+	t.Parallel()
+
+	br := newOpenBroker()
+	srv := service.NewService[int, int](br, slowJob{delay: 300 * time.Millisecond}, service.WithConcurrency(1),
+		service.WithEvents(16))
+
+	errs := make(chan error, 1)
+
+	go func() { errs <- srv.Run() }()
+
+	br.send("1")
+
+	for srv.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); !errors.Is(err, service.ErrUncleanShutdown) {
+		t.Errorf("want %v, got %v", service.ErrUncleanShutdown, err)
+	}
+
+	if err := <-errs; !errors.Is(err, service.ErrUncleanShutdown) {
+		t.Errorf("run: want %v, got %v", service.ErrUncleanShutdown, err)
+	}
+
+	result, ok := srv.ShutdownResult()
+	if !ok || !result.TimedOut || result.InFlight != 1 {
+		t.Errorf("unexpected shutdown result %+v", result)
+	}
+
+	for range srv.Events() { //nolint:revive
+	}
+}
+
+// blockingJob blocks until its context is done and reports when it returned.
+type blockingJob struct {
+	returned chan struct{}
+}
+
+func (j blockingJob) Execute(msg *int) *int {
+	return msg
+}
+
+func (j blockingJob) ExecuteContext(ctx context.Context, msg *int) *int {
+	<-ctx.Done()
+	close(j.returned)
+
+	return msg
+}
+
+// assertReturned fails the test unless the job returned within a second.
+func assertReturned(t *testing.T, job blockingJob) {
+	t.Helper()
+
+	select {
+	case <-job.returned:
+	case <-time.After(time.Second):
+		t.Fatal("job wasn't unblocked")
+	}
+}
+
+// assertNackedOnly checks the only message was negatively acknowledged and not dead-lettered.
+func assertNackedOnly(t *testing.T, br *deadLetterBroker) {
+	t.Helper()
+
+	if nacked := br.Nacked(); len(nacked) != 1 {
+		t.Errorf("want message negatively acknowledged, got %v", nacked)
+	}
+
+	if deadLettered := br.deadLettered.Load(); deadLettered != 0 {
+		t.Errorf("want no dead-lettered message, got %d", deadLettered)
+	}
+}
+
+func TestExecuteTimeoutCancelsJob(t *testing.T) {
+	t.Parallel()
+
+	clock := servicetest.NewClock(time.Now())
+	job := blockingJob{returned: make(chan struct{})}
+	br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("1"))} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, job, service.WithConcurrency(1), service.WithClock(clock),
+		service.WithExecuteTimeout(time.Minute))
+
+	errs := make(chan error, 1)
+
+	go func() { errs <- srv.Run() }()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	assertReturned(t, job)
+	settled(br.Broker, 1)
+	srv.Drain()
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	assertNackedOnly(t, br)
+}
+
+func TestShutdownCancelsJob(t *testing.T) {
+	t.Parallel()
+
+	job := blockingJob{returned: make(chan struct{})}
+	br := &deadLetterBroker{Broker: servicetest.NewBroker([]byte("1"))} //nolint:exhaustruct
+	srv := service.NewService[int, int](br, job, service.WithConcurrency(1))
+
+	go func() { _ = srv.Run() }()
+
+	for srv.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := make(chan error, 1)
+
+	go func() { errs <- srv.Shutdown(ctx) }()
+
+	assertReturned(t, job)
+
+	if err := <-errs; !errors.Is(err, service.ErrUncleanShutdown) {
+		t.Errorf("want %v, got %v", service.ErrUncleanShutdown, err)
+	}
+
+	assertNackedOnly(t, br)
+}
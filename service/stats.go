@@ -0,0 +1,103 @@
+package service
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains service counters.
+type Stats struct {
+	// Number of messages being processed right now.
+	InFlight int64
+	// Highest number of messages processed simultaneously so far. If it's consistently equal to concurrency,
+	// workers are the bottleneck, if it's well below, the broker doesn't deliver fast enough.
+	PeakInFlight int64
+	// Number of errors reported by the broker, like transport errors or subscription closed unexpectedly.
+	BrokerErrors int64
+	// Number of messages for which shadow job output differed from the primary one.
+	ShadowMismatches int64
+}
+
+// counters tracks service stats and is safe for concurrent use.
+type counters struct {
+	inFlight     atomic.Int64
+	peakInFlight atomic.Int64
+	brokerErrors atomic.Int64
+	shadowDiffs  atomic.Int64
+	completed    atomic.Int64
+}
+
+// begin marks start of processing a message.
+func (c *counters) begin() {
+	inFlight := c.inFlight.Add(1)
+
+	for {
+		peak := c.peakInFlight.Load()
+		if inFlight <= peak || c.peakInFlight.CompareAndSwap(peak, inFlight) {
+			return
+		}
+	}
+}
+
+// end marks end of processing a message.
+func (c *counters) end() {
+	c.inFlight.Add(-1)
+	c.completed.Add(1)
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		InFlight:         c.inFlight.Load(),
+		PeakInFlight:     c.peakInFlight.Load(),
+		BrokerErrors:     c.brokerErrors.Load(),
+		ShadowMismatches: c.shadowDiffs.Load(),
+	}
+}
+
+// ShutdownResult describes outcome of graceful shutdown.
+type ShutdownResult struct {
+	// Number of messages being processed when shutdown started.
+	InFlight int64
+	// Number of messages which finished processing while draining.
+	Completed int64
+	// Whether shutdown timeout expired before all in-flight messages finished or were acknowledged by the job.
+	TimedOut bool
+	// Number of messages never acknowledged by the job in manual ack mode.
+	Unacked int64
+}
+
+// MessageStats contains time spent in each stage of processing a single message.
+type MessageStats struct {
+	Decode  time.Duration
+	Execute time.Duration
+	Encode  time.Duration
+}
+
+// stopwatch measures durations between laps. Disabled stopwatch doesn't read the clock at all.
+type stopwatch struct {
+	enabled bool
+	last    time.Time
+}
+
+func newStopwatch(enabled bool) stopwatch {
+	watch := stopwatch{enabled: enabled} //nolint:exhaustruct
+
+	if enabled {
+		watch.last = time.Now()
+	}
+
+	return watch
+}
+
+// lap returns time passed since previous lap or since the stopwatch was created.
+func (w *stopwatch) lap() time.Duration {
+	if !w.enabled {
+		return 0
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(w.last)
+	w.last = now
+
+	return elapsed
+}